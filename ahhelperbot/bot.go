@@ -1,12 +1,17 @@
 package ahhelperbot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
 	"github.com/baor/ah-helper-bot/storage"
 	"github.com/baor/ah-helper-bot/telegram"
 )
@@ -14,20 +19,36 @@ import (
 // Bot is a telegram bot which returns events and sends messages
 type Bot struct {
 	messenger telegram.Messenger
+	bus       *events.Bus
 
-	reAddme         *regexp.Regexp
-	reRemoveme      *regexp.Regexp
-	reCheckDelivery *regexp.Regexp
+	// botName is the telegram username of bot, commands addressed to other bots are ignored
+	botName string
+	router  *commandRouter
 
 	storage storage.DataStorer
+	// chatLocks serialize read-modify-write of subscriptions by chat, e.g. /addme and /filter sent at once
+	chatLocks   map[domain.ChatID]*chatLock
+	chatLocksMu sync.Mutex
 
 	deliveryProvider DeliveryProvider
-}
 
-// PubSubMessage is the payload of a Pub/Sub event. Please refer to the docs for
-// additional information regarding Pub/Sub events.
-type pubSubMessage struct {
-	Data []byte `json:"data"`
+	// lastSchedules keeps the schedule seen on the previous check by postcode. It is not kept over restarts,
+	// so the first check of a postcode after start is a baseline which is not notified
+	lastSchedules   map[domain.Postcode]DeliverySchedule
+	lastSchedulesMu sync.Mutex
+	// notified keeps slots of every postcode of chat which passed its filter on the previous check
+	// and were notified. Any slot which passes the filter later, e.g. enters the lead time, is notified then
+	notified map[domain.ChatID]map[domain.Postcode]DeliverySchedule
+	// removed chats have no subscription anymore, checks in progress do not set their notified slots
+	removed       map[domain.ChatID]bool
+	notifiedMu    sync.Mutex
+	notifyRemoved bool
+
+	// workers is a number of postcodes checked concurrently
+	workers int
+
+	// now returns the current time, lead time of filters is counted from it
+	now func() time.Time
 }
 
 // NewBot returns an instance of Bot which implements Messenger interface
@@ -35,11 +56,18 @@ type pubSubMessage struct {
 func NewBot(storage storage.DataStorer, deliveryProvider DeliveryProvider) *Bot {
 	b := Bot{}
 
-	b.reAddme = regexp.MustCompile(`\/addme (\d{4}\w{2})`)
+	b.router = newCommandRouter()
+	b.registerCommands()
 
 	b.storage = storage
+	b.chatLocks = map[domain.ChatID]*chatLock{}
 
 	b.deliveryProvider = deliveryProvider
+	b.lastSchedules = map[domain.Postcode]DeliverySchedule{}
+	b.notified = map[domain.ChatID]map[domain.Postcode]DeliverySchedule{}
+	b.removed = map[domain.ChatID]bool{}
+	b.workers = 1
+	b.now = time.Now
 	return &b
 }
 
@@ -48,100 +76,826 @@ func (b *Bot) SetMessenger(messenger telegram.Messenger) {
 	b.messenger = messenger
 }
 
-// CheckDeliveries checks delivery for subscripions
-func (b *Bot) CheckDeliveries() {
-	for _, subscription := range b.storage.GetSubscriptions() {
-		b.checkDelivery(subscription)
+// SetEventBus subscribes bot to commands and scan requests of bus.
+// Changed schedules and sent notifications are published on bus
+func (b *Bot) SetEventBus(bus *events.Bus) {
+	b.bus = bus
+	bus.Subscribe(b.handleEvent)
+	if recorder, ok := b.storage.(storage.NotificationRecorder); ok {
+		bus.Subscribe(func(ctx context.Context, event events.Event) error {
+			if e, ok := event.(events.NotificationSent); ok {
+				return recorder.MarkNotified(ctx, e.ChatID, e.At)
+			}
+			return nil
+		})
+	}
+}
+
+// handleEvent processes commands of users and scan requests
+func (b *Bot) handleEvent(ctx context.Context, event events.Event) error {
+	switch e := event.(type) {
+	case events.CommandReceived:
+		b.DefaultMessageProcessor(ctx, e.Message)
+	case events.ScanRequested:
+		summary, err := b.scan(ctx, e)
+		if e.Summary != nil {
+			*e.Summary = summary
+		}
+		return err
+	}
+	return nil
+}
+
+// scan checks deliveries in scope of request
+func (b *Bot) scan(ctx context.Context, request events.ScanRequested) (domain.CheckSummary, error) {
+	log.Printf("scan is requested by %s: %+v", request.Source, request)
+	switch {
+	case len(request.Postcode) > 0:
+		return b.CheckPostcode(ctx, request.Postcode)
+	case request.ChatID != 0:
+		return b.CheckChat(ctx, request.ChatID)
+	}
+	return b.CheckDeliveries(ctx)
+}
+
+// publish event on bus, if bot is subscribed to it
+func (b *Bot) publish(ctx context.Context, event events.Event) {
+	if b.bus == nil {
+		return
+	}
+	if err := b.bus.Publish(ctx, event); err != nil {
+		log.Printf("failed to process event %s: %v", event.Name(), err)
+	}
+}
+
+// SetBotName sets telegram username of bot, e.g. AHHelperBot
+func (b *Bot) SetBotName(botName string) {
+	b.botName = botName
+}
+
+// SetNotifyRemoved enables notifications about slots which are not available anymore
+func (b *Bot) SetNotifyRemoved(notifyRemoved bool) {
+	b.notifyRemoved = notifyRemoved
+}
+
+// SetWorkers sets a number of postcodes which are checked concurrently
+func (b *Bot) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	b.workers = workers
+}
+
+// CheckDeliveries checks delivery for subscripions and notifies subscribers
+// only when new slots appear since the previous check.
+// Schedule of every postcode is requested once, even if several chats are subscribed to it.
+// Postcodes are checked concurrently, the check stops when ctx is done
+func (b *Bot) CheckDeliveries(ctx context.Context) (domain.CheckSummary, error) {
+	subscriptions, err := b.storage.GetSubscriptions(ctx)
+	if err != nil {
+		return domain.CheckSummary{}, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	return b.checkSubscriptions(ctx, subscriptions, subscribedPostcodes(subscriptions))
+}
+
+// CheckPostcode checks delivery only for postcode and notifies all its subscribers
+func (b *Bot) CheckPostcode(ctx context.Context, postcode domain.Postcode) (domain.CheckSummary, error) {
+	subscriptions, err := b.storage.GetSubscriptions(ctx)
+	if err != nil {
+		return domain.CheckSummary{}, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	postcodes := []domain.Postcode{}
+	for _, subscription := range subscriptions {
+		if subscription.HasPostcode(postcode) {
+			postcodes = append(postcodes, postcode)
+			break
+		}
+	}
+	return b.checkSubscriptions(ctx, subscriptions, postcodes)
+}
+
+// CheckChat checks delivery for postcodes of the chat. Other chats subscribed to the same postcodes
+// are notified too, otherwise they would miss changes seen by this check.
+// It returns storage.ErrNotFound if the chat is not subscribed
+func (b *Bot) CheckChat(ctx context.Context, chatID domain.ChatID) (domain.CheckSummary, error) {
+	subscription, err := b.storage.GetSubscriptionByID(ctx, chatID)
+	if err != nil {
+		return domain.CheckSummary{}, fmt.Errorf("failed to get subscription of chat %s: %w", chatID, err)
+	}
+	subscriptions, err := b.storage.GetSubscriptions(ctx)
+	if err != nil {
+		return domain.CheckSummary{}, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	return b.checkSubscriptions(ctx, subscriptions, subscribedPostcodes([]domain.Subscription{subscription}))
+}
+
+// checkSubscriptions checks postcodes and notifies subscriptions about their changes
+func (b *Bot) checkSubscriptions(ctx context.Context, subscriptions []domain.Subscription, postcodes []domain.Postcode) (domain.CheckSummary, error) {
+	schedules, summary := b.checkPostcodes(ctx, postcodes)
+
+	for _, subscription := range subscriptions {
+		b.notifyChanges(ctx, subscription, schedules, &summary)
+	}
+
+	log.Printf("check deliveries: %s", summary)
+	return summary, ctx.Err()
+}
+
+// scheduleChange is a difference between schedules of postcode since the last check
+type scheduleChange struct {
+	added   DeliverySchedule
+	removed DeliverySchedule
+	// baseline is set on the first check of postcode since start. Its slots could be notified
+	// before the start, so they are not notified as added
+	baseline bool
+}
+
+// checkedSchedule is the result of check of postcode
+type checkedSchedule struct {
+	// slots are available slots of postcode
+	slots    DeliverySchedule
+	baseline bool
+}
+
+// checkPostcodes requests schedules for postcodes by the pool of workers
+// and returns them by postcode. Failed and not checked postcodes have no schedule.
+// Changes since the previous check are published as ScheduleChanged
+func (b *Bot) checkPostcodes(ctx context.Context, postcodes []domain.Postcode) (map[domain.Postcode]checkedSchedule, domain.CheckSummary) {
+	summary := domain.CheckSummary{Postcodes: len(postcodes)}
+	schedules := map[domain.Postcode]checkedSchedule{}
+	mu := sync.Mutex{}
+
+	queue := make(chan domain.Postcode)
+	wg := sync.WaitGroup{}
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for postcode := range queue {
+				schedule, change, err := b.checkSchedule(ctx, postcode)
+
+				if err != nil {
+					if errors.Is(err, ErrChecksPaused) {
+						mu.Lock()
+						summary.Paused++
+						mu.Unlock()
+					}
+					continue
+				}
+				mu.Lock()
+				summary.Succeeded++
+				schedules[postcode] = checkedSchedule{slots: schedule, baseline: change.baseline}
+				mu.Unlock()
+				if change.baseline {
+					log.Printf("schedule of %s is checked first time since start, it is not notified", postcode)
+					continue
+				}
+				if text := b.changeText(postcode, change.added, change.removed, defaultLanguage); len(text) > 0 {
+					b.publish(ctx, events.ScheduleChanged{Postcode: postcode, Changes: text.String()})
+				}
+			}
+		}()
+	}
+
+	for _, postcode := range postcodes {
+		select {
+		case queue <- postcode:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	summary.Failed = summary.Postcodes - summary.Succeeded
+	return schedules, summary
+}
+
+// subscribedPostcodes returns sorted unique postcodes of subscriptions
+func subscribedPostcodes(subscriptions []domain.Subscription) []domain.Postcode {
+	seen := map[domain.Postcode]bool{}
+	postcodes := []domain.Postcode{}
+	for _, subscription := range subscriptions {
+		for _, postcode := range subscription.Postcodes {
+			if seen[postcode] {
+				continue
+			}
+			seen[postcode] = true
+			postcodes = append(postcodes, postcode)
+		}
+	}
+	sort.Slice(postcodes, func(i, j int) bool {
+		return postcodes[i] < postcodes[j]
+	})
+	return postcodes
+}
+
+// notifyChanges sends slots of checked postcodes of subscription which pass its filter and were not notified
+// to the chat yet. Texts are in the language of subscription, notifications are counted in summary.
+// Subscription of chat which is not available anymore is removed
+func (b *Bot) notifyChanges(ctx context.Context, subscription domain.Subscription, schedules map[domain.Postcode]checkedSchedule, summary *domain.CheckSummary) {
+	now := b.now()
+	previous := b.notifiedSlots(subscription.ChatID)
+	current := map[domain.Postcode]DeliverySchedule{}
+	text := domain.RichText{}
+	changed := []domain.Postcode{}
+	for _, postcode := range subscription.Postcodes {
+		checked, ok := schedules[postcode]
+		if !ok {
+			// the postcode is not checked or failed, its slots are compared on the next check
+			if prev, ok := previous[postcode]; ok {
+				current[postcode] = prev
+			}
+			continue
+		}
+		filtered := checked.slots.Filter(subscription.Filter, now)
+		current[postcode] = filtered
+		if checked.baseline {
+			continue
+		}
+		// removed slots are the notified ones which have disappeared at AH, not the ones out of filter now
+		added, removed := filtered.subtract(previous[postcode]), previous[postcode].subtract(checked.slots)
+
+		postcodeText := b.changeText(postcode, added, removed, subscription.LanguageCode)
+		if len(postcodeText) == 0 {
+			continue
+		}
+		changed = append(changed, postcode)
+		text = append(text, postcodeText...)
+	}
+	if len(text) == 0 {
+		b.setNotifiedSlots(subscription.ChatID, current)
+		log.Printf("no changes in delivery schedule for %+v", subscription)
+		return
+	}
+
+	err := b.send(domain.Message{
+		ChatID:    subscription.ChatID,
+		Rich:      text,
+		Broadcast: true})
+	if errors.Is(err, telegram.ErrChatUnavailable) {
+		b.deactivate(ctx, subscription, summary)
+		return
+	}
+	if err != nil {
+		// slots are notified again on the next check
+		summary.NotificationsFailed++
+		return
+	}
+	b.setNotifiedSlots(subscription.ChatID, current)
+	summary.Notified++
+
+	b.publish(ctx, events.NotificationSent{
+		ChatID:    subscription.ChatID,
+		Postcodes: changed,
+		At:        b.now(),
+	})
+}
+
+// notifiedSlots returns slots notified to chat by postcode
+func (b *Bot) notifiedSlots(chatID domain.ChatID) map[domain.Postcode]DeliverySchedule {
+	b.notifiedMu.Lock()
+	defer b.notifiedMu.Unlock()
+	return b.notified[chatID]
+}
+
+// setRemoved forgets slots notified to chat whose subscription is removed, or allows
+// notifying it again after it has subscribed again
+func (b *Bot) setRemoved(chatID domain.ChatID, removed bool) {
+	b.notifiedMu.Lock()
+	defer b.notifiedMu.Unlock()
+	if !removed {
+		delete(b.removed, chatID)
+		return
+	}
+	delete(b.notified, chatID)
+	b.removed[chatID] = true
+}
+
+// setNotifiedSlots replaces slots notified to chat. Postcodes which are not in slots are forgotten.
+// Slots of removed chat are not set
+func (b *Bot) setNotifiedSlots(chatID domain.ChatID, slots map[domain.Postcode]DeliverySchedule) {
+	b.notifiedMu.Lock()
+	defer b.notifiedMu.Unlock()
+	if len(slots) == 0 || b.removed[chatID] {
+		delete(b.notified, chatID)
+		return
+	}
+	b.notified[chatID] = slots
+}
+
+// checkSchedule requests the current schedule for postcode and returns its available slots
+// with changes since the last check
+func (b *Bot) checkSchedule(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, scheduleChange, error) {
+	deliverySchedule, err := b.deliveryProvider.Get(ctx, postcode)
+	if err != nil {
+		log.Printf("failed to check deliveries for postcode %s: %v", postcode, err)
+		return nil, scheduleChange{}, err
+	}
+
+	deliverySchedule = deliverySchedule.Available()
+	b.lastSchedulesMu.Lock()
+	last, checked := b.lastSchedules[postcode]
+	b.lastSchedules[postcode] = deliverySchedule
+	b.lastSchedulesMu.Unlock()
+	added, removed := deliverySchedule.Diff(last)
+	return deliverySchedule, scheduleChange{added: added, removed: removed, baseline: !checked}, nil
+}
+
+// titles of notifications about changed slots of postcode
+var (
+	addedSlotsTitle = localized{
+		"en": "New delivery slots for %s:\n",
+		"nl": "Nieuwe bezorgmomenten voor %s:\n",
+	}
+	removedSlotsTitle = localized{
+		"en": "Delivery slots for %s are not available anymore:\n",
+		"nl": "Bezorgmomenten voor %s zijn niet meer beschikbaar:\n",
+	}
+)
+
+// changeText returns description of added and removed slots of postcode in language.
+// Removed slots are described only if notifyRemoved is set. It returns empty text if nothing has changed
+func (b *Bot) changeText(postcode domain.Postcode, added DeliverySchedule, removed DeliverySchedule, language string) domain.RichText {
+	text := domain.RichText{}
+	if len(added) > 0 {
+		text = append(text, domain.Plainf(addedSlotsTitle.in(language), postcode))
+		text = append(text, added.RichText(language)...)
+	}
+	if b.notifyRemoved && len(removed) > 0 {
+		text = append(text, domain.Plainf(removedSlotsTitle.in(language), postcode))
+		text = append(text, removed.RichText(language)...)
+	}
+	return text
+}
+
+// chatLock is a lock of chat which is removed when nobody holds or waits for it
+type chatLock struct {
+	mu      sync.Mutex
+	holders int
+}
+
+// lockChat waits until other updates of subscription of chat are done and returns the unlock function
+func (b *Bot) lockChat(chatID domain.ChatID) func() {
+	b.chatLocksMu.Lock()
+	lock, ok := b.chatLocks[chatID]
+	if !ok {
+		lock = &chatLock{}
+		b.chatLocks[chatID] = lock
+	}
+	lock.holders++
+	b.chatLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		b.chatLocksMu.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(b.chatLocks, chatID)
+		}
+		b.chatLocksMu.Unlock()
 	}
 }
 
-func (b *Bot) checkDeliveryByID(c domain.ChatID) {
-	sub := b.storage.GetSubscriptionByID(c)
-	b.checkDelivery(sub)
+// getSubscription returns subscription of the chat. If the chat is not subscribed
+// or subscription cannot be read, user is informed and ok is false
+func (b *Bot) getSubscription(ctx context.Context, c domain.ChatID) (sub domain.Subscription, ok bool) {
+	sub, err := b.storage.GetSubscriptionByID(ctx, c)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   "You are not subscribed. Register with /addme 1234AB"})
+		return sub, false
+	}
+	if err != nil {
+		log.Printf("failed to get subscription for chat %v: %v", c, err)
+		b.sendMessageFailure(c)
+		return sub, false
+	}
+	return sub, true
 }
 
-func (b *Bot) checkDelivery(subscription domain.Subscription) {
-	if subscription.ChatID == 0 {
+func (b *Bot) checkDeliveryByID(ctx context.Context, c domain.ChatID, language string) {
+	sub, ok := b.getSubscription(ctx, c)
+	if !ok {
 		return
 	}
+	b.checkDelivery(ctx, sub, language)
+}
 
-	if len(subscription.Postcode) == 0 {
+// checkDelivery sends schedules for all postcodes of subscription, each postcode in its own section.
+// Dates are shown in language of user
+func (b *Bot) checkDelivery(ctx context.Context, subscription domain.Subscription, language string) {
+	if len(subscription.Postcodes) == 0 {
 		b.send(domain.Message{
 			ChatID: subscription.ChatID,
-			Text:   "Postcode was not found. Try to register again with /addme 1234AB"})
+			Text:   "You have no postcodes. Add one with /addme 1234AB"})
 		return
 	}
 
-	deliverySchedule := b.deliveryProvider.Get(subscription.Postcode)
-	scheduleText := deliverySchedule.String()
-	if len(scheduleText) == 0 {
-		scheduleText = fmt.Sprintf("No deliveries available for %s", subscription.Postcode)
+	text := domain.RichText{}
+	for i, postcode := range subscription.Postcodes {
+		if i > 0 {
+			text = append(text, domain.Plain("\n"))
+		}
+		text = append(text, domain.Bold(postcode.String()), domain.Plain("\n"))
+		text = append(text, b.scheduleText(ctx, postcode, language, subscription.Filter)...)
 	}
 	b.send(domain.Message{
 		ChatID: subscription.ChatID,
-		Text:   scheduleText})
+		Rich:   text})
 }
 
-// send message to the telegram chat
-func (b *Bot) send(msg domain.Message) {
-	if len(msg.Text) > 4096 {
-		log.Printf("trim too long string %s", msg.Text)
-		msg.Text = msg.Text[:4090] + "..."
+// scheduleText returns the current schedule for postcode with dates in language. Only slots which pass filter are shown
+func (b *Bot) scheduleText(ctx context.Context, postcode domain.Postcode, language string, filter domain.Filter) domain.RichText {
+	deliverySchedule, err := b.deliveryProvider.Get(ctx, postcode)
+	if err != nil {
+		log.Printf("failed to check deliveries for postcode %s: %v", postcode, err)
+		return domain.RichText{domain.Plain(deliveryErrorText(postcode, err, language) + "\n")}
+	}
+	available := deliverySchedule.Available()
+	text := available.Filter(filter, b.now()).RichText(language)
+	if len(text) > 0 {
+		return text
+	}
+	if len(available.RichText(language)) > 0 {
+		return domain.RichText{domain.Plainf(noFilteredDeliveriesText.in(language), postcode, filter)}
 	}
-	b.messenger.Send(msg)
+	return domain.RichText{domain.Plainf(noDeliveriesText.in(language), postcode)}
 }
 
-func (b *Bot) sendMessageHelp(chatID domain.ChatID) {
-	msg := `Help for the AH chatbot.
-	+ In order to register or update information, please enter your postcode in format 
-	/addme 1234AB
+// texts of schedules without available slots
+var (
+	noDeliveriesText = localized{
+		"en": "No deliveries available for %s\n",
+		"nl": "Geen bezorgmomenten beschikbaar voor %s\n",
+	}
+	noFilteredDeliveriesText = localized{
+		"en": "No deliveries available for %s match your filters: %s\n",
+		"nl": "Geen beschikbare bezorgmomenten voor %s passen bij je filters: %s\n",
+	}
+)
 
-	+ To remove your registration, enter
-	/unsubscribe
+func (b *Bot) listPostcodes(ctx context.Context, c domain.ChatID) {
+	sub, ok := b.getSubscription(ctx, c)
+	if !ok {
+		return
+	}
+	if len(sub.Postcodes) == 0 {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   "You have no postcodes. Add one with /addme 1234AB"})
+		return
+	}
 
-	+ To check available deliveries for your postcode enter
-	/check
+	var text strings.Builder
+	text.WriteString("Your postcodes:\n")
+	for i, postcode := range sub.Postcodes {
+		text.WriteString(fmt.Sprintf("%d. %s\n", i+1, postcode))
+	}
+	b.send(domain.Message{
+		ChatID: c,
+		Text:   text.String()})
+}
 
-	any other input will show this message
-	`
-	b.messenger.Send(domain.Message{ChatID: chatID, Text: msg})
+// parsePostcode parses postcode entered by user. User is informed about the reason if it's invalid
+func (b *Bot) parsePostcode(c domain.ChatID, input string) (domain.Postcode, bool) {
+	postcode, err := domain.ParsePostcode(input)
+	if err != nil {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   fmt.Sprintf("Postcode '%s' is not valid: %v", input, err)})
+		return "", false
+	}
+	return postcode, true
 }
 
-// DefaultMessageProcessor is a processor for messages to bot
-func (b *Bot) DefaultMessageProcessor(msg domain.Message) {
-	if strings.HasPrefix(msg.Text, "/check") {
-		b.checkDeliveryByID(msg.ChatID)
+// rememberLanguage keeps language of the user in subscription, so notifications are sent in it.
+// Telegram doesn't always send the language, the known one is kept then
+func rememberLanguage(sub *domain.Subscription, language string) {
+	if len(language) > 0 {
+		sub.LanguageCode = language
+	}
+}
+
+func (b *Bot) addPostcode(ctx context.Context, c domain.ChatID, input string, language string) {
+	postcode, ok := b.parsePostcode(c, input)
+	if !ok {
 		return
 	}
 
-	if strings.HasPrefix(msg.Text, "/unsubscribe") {
-		sub := domain.Subscription{
-			ChatID: msg.ChatID,
+	defer b.lockChat(c)()
+	sub, err := b.storage.GetSubscriptionByID(ctx, c)
+	if errors.Is(err, storage.ErrNotFound) {
+		sub = domain.Subscription{ChatID: c}
+	} else if err != nil {
+		log.Printf("failed to get subscription for chat %v: %v", c, err)
+		b.sendMessageFailure(c)
+		return
+	}
+
+	if !sub.AddPostcode(postcode) {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   fmt.Sprintf("You are already subscribed to postcode %s", postcode)})
+		return
+	}
+	rememberLanguage(&sub, language)
+	log.Printf("message processor add subscription: %+v", sub)
+	if err := b.storage.AddSubscription(ctx, sub); err != nil {
+		log.Printf("failed to add subscription %+v: %v", sub, err)
+		b.sendMessageFailure(c)
+		return
+	}
+	b.setRemoved(c, false)
+	b.send(domain.Message{
+		ChatID: c,
+		Text:   fmt.Sprintf("Subscription for postcode %s was successful", postcode)})
+}
+
+func (b *Bot) removePostcode(ctx context.Context, c domain.ChatID, input string, language string) {
+	postcode, ok := b.parsePostcode(c, input)
+	if !ok {
+		return
+	}
+
+	defer b.lockChat(c)()
+	sub, ok := b.getSubscription(ctx, c)
+	if !ok {
+		return
+	}
+	if !sub.RemovePostcode(postcode) {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   fmt.Sprintf("You are not subscribed to postcode %s", postcode)})
+		return
+	}
+	rememberLanguage(&sub, language)
+
+	log.Printf("message processor remove postcode %s: %+v", postcode, sub)
+	var err error
+	if len(sub.Postcodes) == 0 {
+		err = b.storage.RemoveSubscription(ctx, sub)
+	} else {
+		err = b.storage.AddSubscription(ctx, sub)
+	}
+	if err != nil {
+		log.Printf("failed to remove postcode %s from subscription %+v: %v", postcode, sub, err)
+		b.sendMessageFailure(c)
+		return
+	}
+	b.send(domain.Message{
+		ChatID: c,
+		Text:   fmt.Sprintf("Postcode %s was removed", postcode)})
+}
+
+// deliveryErrorText returns a message for user about failed delivery check in language
+func deliveryErrorText(postcode domain.Postcode, err error, language string) string {
+	if errors.Is(err, ErrUnknownPostcode) {
+		return fmt.Sprintf(unknownPostcodeText.in(language), postcode)
+	}
+	if errors.Is(err, ErrChecksPaused) {
+		return checksPausedText.in(language)
+	}
+	if errors.Is(err, ErrInvalidPayload) {
+		return fmt.Sprintf(invalidScheduleText.in(language), postcode)
+	}
+	if errors.Is(err, ErrMisconfigured) {
+		return misconfiguredText.in(language)
+	}
+	return unreachableText.in(language)
+}
+
+// texts of failed delivery checks
+var (
+	unknownPostcodeText = localized{
+		"en": "Postcode %s is not known by AH. Try to register again with /addme 1234AB",
+		"nl": "Postcode %s is niet bekend bij AH. Probeer opnieuw te registreren met /addme 1234AB",
+	}
+	checksPausedText = localized{
+		"en": "AH checks are paused after repeated failures, will resume later",
+		"nl": "AH controles zijn gepauzeerd na herhaalde fouten, ze worden later hervat",
+	}
+	invalidScheduleText = localized{
+		"en": "Schedule of AH for %s can't be read, will retry",
+		"nl": "Schema van AH voor %s kan niet gelezen worden, wordt opnieuw geprobeerd",
+	}
+	misconfiguredText = localized{
+		"en": "AH checks are not available because of a problem of the bot",
+		"nl": "AH controles zijn niet beschikbaar door een probleem van de bot",
+	}
+	unreachableText = localized{
+		"en": "AH is unreachable, will retry",
+		"nl": "AH is onbereikbaar, wordt opnieuw geprobeerd",
+	}
+)
+
+// deactivate removes subscription of chat which is not available anymore, e.g. the user has blocked the bot
+func (b *Bot) deactivate(ctx context.Context, subscription domain.Subscription, summary *domain.CheckSummary) {
+	defer b.lockChat(subscription.ChatID)()
+	log.Printf("chat %s is not available anymore, remove subscription %+v", subscription.ChatID, subscription)
+	b.setRemoved(subscription.ChatID, true)
+	if err := b.storage.RemoveSubscription(ctx, subscription); err != nil {
+		log.Printf("failed to remove subscription %+v: %v", subscription, err)
+		summary.NotificationsFailed++
+		return
+	}
+	summary.Deactivated++
+}
+
+// send message to the telegram chat. Long message is sent in several parts in order,
+// the rest is not sent after a failure. Failures are logged and returned
+func (b *Bot) send(msg domain.Message) error {
+	for _, part := range splitMessage(msg, telegram.MaxMessageLength) {
+		if err := b.messenger.Send(part); err != nil {
+			log.Printf("failed to send message to chat %s: %v", msg.ChatID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage splits text of message into messages of at most limit UTF-16 code units
+func splitMessage(msg domain.Message, limit int) []domain.Message {
+	text := msg.Rich
+	if len(text) == 0 {
+		text = domain.RichText{domain.Plain(msg.Text)}
+	}
+	parts := text.Split(limit)
+	if len(parts) > 1 {
+		log.Printf("split message to chat %s into %d parts", msg.ChatID, len(parts))
+	}
+
+	msgs := []domain.Message{}
+	for _, part := range parts {
+		m := msg
+		if len(msg.Rich) > 0 {
+			m.Rich = part
+		} else {
+			m.Text = part.String()
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func (b *Bot) sendMessageFailure(chatID domain.ChatID) {
+	b.send(domain.Message{
+		ChatID: chatID,
+		Text:   "Something went wrong, please try again later"})
+}
+
+func (b *Bot) sendMessageHelp(chatID domain.ChatID, language string) {
+	b.send(domain.Message{ChatID: chatID, Text: b.router.help(language)})
+}
+
+// registerCommands registers commands of bot in router
+func (b *Bot) registerCommands() {
+	b.router.register(command{
+		name: "addme",
+		args: "1234AB",
+		description: localized{
+			"en": "register a postcode, you can register several postcodes",
+			"nl": "registreer een postcode, je kunt meerdere postcodes registreren",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.addPostcode(ctx, msg.ChatID, args, msg.LanguageCode)
+		},
+	})
+	b.router.register(command{
+		name: "list",
+		description: localized{
+			"en": "show your postcodes",
+			"nl": "toon je postcodes",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.listPostcodes(ctx, msg.ChatID)
+		},
+	})
+	b.router.register(command{
+		name: "removeme",
+		args: "1234AB",
+		description: localized{
+			"en": "remove one of your postcodes",
+			"nl": "verwijder een van je postcodes",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.removePostcode(ctx, msg.ChatID, args, msg.LanguageCode)
+		},
+	})
+	b.router.register(command{
+		name: "unsubscribe",
+		description: localized{
+			"en": "remove your registration",
+			"nl": "verwijder je registratie",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.unsubscribe(ctx, msg.ChatID)
+		},
+	})
+	b.router.register(command{
+		name: "check",
+		description: localized{
+			"en": "check available deliveries for your postcodes",
+			"nl": "bekijk beschikbare bezorgmomenten voor je postcodes",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.checkDeliveryByID(ctx, msg.ChatID, msg.LanguageCode)
+		},
+	})
+	b.router.register(command{
+		name: "filter",
+		args: "weekdays mo-fr 18:00-22:00",
+		description: localized{
+			"en": "show only slots on weekdays, in time windows, within lead time (lead 2h-3d) or up to a price (price 5.95); /filter show, /filter clear",
+			"nl": "toon alleen momenten op weekdagen, in tijdvakken, binnen een termijn (lead 2h-3d) of tot een prijs (price 5.95); /filter show, /filter clear",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.setFilter(ctx, msg.ChatID, args, msg.LanguageCode)
+		},
+	})
+	b.router.register(command{
+		name: "help",
+		description: localized{
+			"en": "show this message",
+			"nl": "toon dit bericht",
+		},
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.sendMessageHelp(msg.ChatID, msg.LanguageCode)
+		},
+	})
+	b.router.register(command{
+		name:   "start",
+		hidden: true,
+		handler: func(ctx context.Context, msg domain.Message, args string) {
+			b.sendMessageHelp(msg.ChatID, msg.LanguageCode)
+		},
+	})
+}
+
+// PublishCommands publishes commands with descriptions to telegram, so clients show them in autocomplete.
+// Commands are published for users of every supported language, English is the default
+func (b *Bot) PublishCommands() error {
+	for _, language := range languages {
+		languageCode := language
+		if language == defaultLanguage {
+			languageCode = ""
+		}
+		if err := b.messenger.SetCommands(b.router.botCommands(language), languageCode); err != nil {
+			return fmt.Errorf("failed to publish commands for language '%s': %w", language, err)
 		}
-		log.Printf("message processor remove subscription: %+v", sub)
-		b.storage.RemoveSubscription(sub)
-		b.messenger.Send(domain.Message{
+	}
+	return nil
+}
+
+func (b *Bot) unsubscribe(ctx context.Context, chatID domain.ChatID) {
+	defer b.lockChat(chatID)()
+	sub := domain.Subscription{
+		ChatID: chatID,
+	}
+	log.Printf("message processor remove subscription: %+v", sub)
+	if err := b.storage.RemoveSubscription(ctx, sub); err != nil {
+		log.Printf("failed to remove subscription %+v: %v", sub, err)
+		b.sendMessageFailure(chatID)
+		return
+	}
+	b.setRemoved(chatID, true)
+	b.send(domain.Message{
+		ChatID: chatID,
+		Text:   "Subscription was removed",
+	})
+}
+
+// DefaultMessageProcessor is a processor for messages to bot.
+// Commands addressed to other bots are ignored, any other text shows help
+func (b *Bot) DefaultMessageProcessor(ctx context.Context, msg domain.Message) {
+	parsed, ok := parseCommand(msg.Text)
+	if !ok {
+		b.sendMessageHelp(msg.ChatID, msg.LanguageCode)
+		return
+	}
+	if len(parsed.botName) > 0 && len(b.botName) > 0 && !strings.EqualFold(parsed.botName, b.botName) {
+		log.Printf("command /%s is addressed to bot %s", parsed.name, parsed.botName)
+		return
+	}
+
+	c, ok := b.router.byName[parsed.name]
+	if !ok {
+		b.send(domain.Message{
 			ChatID: msg.ChatID,
-			Text:   fmt.Sprintf("Subscription was removed"),
+			Text:   fmt.Sprintf("Unknown command /%s. Send /help to see available commands", parsed.name),
 		})
 		return
 	}
-
-	match := b.reAddme.FindStringSubmatch(msg.Text)
-	if match != nil {
-		postcode := match[1]
-		sub := domain.Subscription{
-			ChatID:   msg.ChatID,
-			Postcode: postcode,
-		}
-		log.Printf("message processor add subscription: %+v", sub)
-		b.storage.AddSubscription(sub)
-		b.messenger.Send(domain.Message{
+	if len(c.args) > 0 && len(parsed.args) == 0 {
+		b.send(domain.Message{
 			ChatID: msg.ChatID,
-			Text:   fmt.Sprintf("Subscription for postcode %s was successful", postcode),
+			Text:   fmt.Sprintf("Command /%s requires arguments: %s", c.name, c.usage()),
 		})
 		return
 	}
-
-	b.sendMessageHelp(msg.ChatID)
+	c.handler(ctx, msg, parsed.args)
 }
@@ -1,10 +1,18 @@
 package ahhelperbot
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
+	"github.com/baor/ah-helper-bot/storage"
+	"github.com/baor/ah-helper-bot/telegram"
 	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/stretchr/testify/assert"
 )
@@ -13,65 +21,106 @@ type fakeMessenger struct {
 	tlgBotAPI    *tlg.BotAPI
 	updatesCh    chan tlg.Update
 	sentMessages map[domain.ChatID]string
+	// sentRich keeps formatted text of sent messages, its plain text is in sentMessages
+	sentRich map[domain.ChatID]domain.RichText
+	// sent keeps all sent messages in order
+	sent     []domain.Message
+	commands map[string][]telegram.BotCommand
+	// sendErrors are returned by Send for chats
+	sendErrors map[domain.ChatID]error
 }
 
 func newFakeMessenger() *fakeMessenger {
 	b := fakeMessenger{}
 	b.sentMessages = map[domain.ChatID]string{}
+	b.sentRich = map[domain.ChatID]domain.RichText{}
 
 	b.updatesCh = make(chan tlg.Update, 1)
 	return &b
 }
 
-func (b *fakeMessenger) Send(m domain.Message) {
+func (b *fakeMessenger) Send(m domain.Message) error {
+	if err := b.sendErrors[m.ChatID]; err != nil {
+		return err
+	}
 	b.sentMessages[m.ChatID] = m.Text
+	if len(m.Rich) > 0 {
+		b.sentMessages[m.ChatID] = m.Rich.String()
+	}
+	b.sentRich[m.ChatID] = m.Rich
+	b.sent = append(b.sent, m)
+	return nil
 }
 
-type fakeDeliveryProvider struct {
-	date string
+func (b *fakeMessenger) Run(ctx context.Context) {
+	<-ctx.Done()
 }
 
-func (p *fakeDeliveryProvider) Get(postcode string) DeliverySchedule {
-	resp := DeliverySchedule{}
-	resp[p.date] = []DeliveryTimeSlotBase{
-		{
-			From: postcode,
-		},
+func (b *fakeMessenger) UserName() string {
+	return "AHHelperBot"
+}
+
+func (b *fakeMessenger) SetCommands(commands []telegram.BotCommand, languageCode string) error {
+	if b.commands == nil {
+		b.commands = map[string][]telegram.BotCommand{}
 	}
-	return resp
+	b.commands[languageCode] = commands
+	return nil
 }
 
-type fakeDataStorer struct {
-	subscriptions map[domain.ChatID]domain.Subscription
+type fakeDeliveryProvider struct {
+	date  string
+	price float64
+	err   error
 }
 
-func (s *fakeDataStorer) AddSubscription(subscription domain.Subscription) {
-	if s.subscriptions == nil {
-		s.subscriptions = make(map[domain.ChatID]domain.Subscription)
+func (p *fakeDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	if p.err != nil {
+		return nil, p.err
 	}
-	s.subscriptions[subscription.ChatID] = subscription
+	from, to := testSlotTimes(postcode)
+	resp := DeliverySchedule{}
+	slot := newTestSlot(p.date, from, to, 0)
+	slot.Price = p.price
+	resp[p.date] = []domain.Slot{slot}
+	return resp, nil
 }
 
-func (s *fakeDataStorer) RemoveSubscription(subscription domain.Subscription) {
-	delete(s.subscriptions, subscription.ChatID)
+// testSlotTimes returns times of slot of fakeDeliveryProvider, every postcode has its own slot,
+// e.g. 08:00-09:00 for 1234AA and 09:00-10:00 for 1234AB
+func testSlotTimes(postcode domain.Postcode) (string, string) {
+	hour := 8 + int(postcode[5]-'A')
+	return fmt.Sprintf("%02d:00", hour), fmt.Sprintf("%02d:00", hour+1)
 }
 
-func (s *fakeDataStorer) GetSubscriptions() []domain.Subscription {
-	subs := []domain.Subscription{}
-	for _, v := range s.subscriptions {
-		subs = append(subs, v)
+// scheduleLine returns line of schedule of fakeDeliveryProvider for postcode at date
+func scheduleLine(date string, postcode domain.Postcode) string {
+	from, to := testSlotTimes(postcode)
+	return fmt.Sprintf("%s: %s-%s", formatDate(date, defaultLanguage), from, to)
+}
+
+// markChecked makes postcodes of all subscriptions checked since start without slots,
+// so the next check notifies all their slots instead of taking them as a baseline
+func markChecked(t *testing.T, bot *Bot) {
+	subscriptions, err := bot.storage.GetSubscriptions(context.Background())
+	assert.NoError(t, err)
+	for _, postcode := range subscribedPostcodes(subscriptions) {
+		bot.lastSchedules[postcode] = DeliverySchedule{}
 	}
-	return subs
 }
 
-func (s *fakeDataStorer) GetSubscriptionByID(c domain.ChatID) domain.Subscription {
-	return s.subscriptions[c]
+func newTestStorage(subscriptions ...domain.Subscription) storage.DataStorer {
+	s := storage.NewMemoryStorage()
+	for _, sub := range subscriptions {
+		s.AddSubscription(context.Background(), sub)
+	}
+	return s
 }
 
 func TestBot_SendMessage(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	storage := fakeDataStorer{}
-	bot := NewBot(&storage, &fakeDeliveryProvider{})
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
 	bot.SetMessenger(fakeMessenger)
 
 	// Act
@@ -82,8 +131,8 @@ func TestBot_SendMessage(t *testing.T) {
 
 func TestBotMessageProcessor_ProcessHelp(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	storage := fakeDataStorer{}
-	bot := NewBot(&storage, &fakeDeliveryProvider{})
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
 	bot.SetMessenger(fakeMessenger)
 
 	msg := domain.Message{
@@ -91,7 +140,7 @@ func TestBotMessageProcessor_ProcessHelp(t *testing.T) {
 		Text:   "help",
 	}
 	// Act
-	bot.DefaultMessageProcessor(msg)
+	bot.DefaultMessageProcessor(context.Background(), msg)
 
 	sentMsg := fakeMessenger.sentMessages[1]
 	assert.Contains(t, sentMsg, "Help")
@@ -99,8 +148,8 @@ func TestBotMessageProcessor_ProcessHelp(t *testing.T) {
 
 func TestBotMessageProcessor_ProcessAdd(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	storage := fakeDataStorer{}
-	bot := NewBot(&storage, &fakeDeliveryProvider{})
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
 	bot.SetMessenger(fakeMessenger)
 
 	msg := domain.Message{
@@ -108,25 +157,74 @@ func TestBotMessageProcessor_ProcessAdd(t *testing.T) {
 		Text:   "/addme 1234AA",
 	}
 	// Act
-	bot.DefaultMessageProcessor(msg)
+	bot.DefaultMessageProcessor(context.Background(), msg)
 
+	sub, err := s.GetSubscriptionByID(context.Background(), 1)
+	assert.NoError(t, err)
 	assert.Equal(t, domain.Subscription{
-		ChatID:   1,
-		Postcode: "1234AA",
-	}, storage.subscriptions[1])
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+	}, sub)
+}
+
+func TestBotMessageProcessor_ProcessAddKeepsLanguage(t *testing.T) {
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
+	bot.SetMessenger(newFakeMessenger())
+	ctx := context.Background()
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/addme 1234AA", LanguageCode: "nl"})
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/addme 1234AB"})
+
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "nl", sub.LanguageCode)
+	assert.Equal(t, []domain.Postcode{"1234AA", "1234AB"}, sub.Postcodes)
+}
+
+// slowStorage delays reads of subscriptions, so concurrent updates interleave
+type slowStorage struct {
+	storage.DataStorer
+}
+
+func (s *slowStorage) GetSubscriptionByID(ctx context.Context, chatID domain.ChatID) (domain.Subscription, error) {
+	time.Sleep(10 * time.Millisecond)
+	return s.DataStorer.GetSubscriptionByID(ctx, chatID)
+}
+
+func TestBotMessageProcessor_ConcurrentUpdatesOfChat(t *testing.T) {
+	s := &slowStorage{DataStorer: newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})}
+	bot := NewBot(s, &fakeDeliveryProvider{})
+	bot.SetMessenger(newFakeMessenger())
+	ctx := context.Background()
+	commands := []string{"/addme 1234AB", "/addme 1234AC", "/filter price 5.95", "/removeme 1234AA"}
+	wg := sync.WaitGroup{}
+
+	// Act
+	for _, text := range commands {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: text})
+		}(text)
+	}
+	wg.Wait()
+
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Postcode{"1234AB", "1234AC"}, sub.Postcodes)
+	assert.Equal(t, 5.95, sub.Filter.MaxPrice)
+	assert.Empty(t, bot.chatLocks)
 }
 
 func TestBotMessageProcessor_ProcessRemove(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	storage := fakeDataStorer{
-		subscriptions: map[domain.ChatID]domain.Subscription{
-			1: domain.Subscription{
-				ChatID:   1,
-				Postcode: "1234AA",
-			},
-		},
-	}
-	bot := NewBot(&storage, &fakeDeliveryProvider{})
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+	})
+	bot := NewBot(s, &fakeDeliveryProvider{})
 	bot.SetMessenger(fakeMessenger)
 
 	msg := domain.Message{
@@ -135,28 +233,26 @@ func TestBotMessageProcessor_ProcessRemove(t *testing.T) {
 	}
 
 	// Act
-	bot.DefaultMessageProcessor(msg)
+	bot.DefaultMessageProcessor(context.Background(), msg)
 
-	assert.Equal(t, 0, len(storage.subscriptions))
+	subs, err := s.GetSubscriptions(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, subs)
 }
 
 func TestBotMessageProcessor_ProcessCheck(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	postcode := "1234AA"
-	storage := fakeDataStorer{
-		subscriptions: map[domain.ChatID]domain.Subscription{
-			1: domain.Subscription{
-				ChatID:   1,
-				Postcode: postcode,
-			},
-		},
-	}
+	postcode := domain.Postcode("1234AA")
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{postcode},
+	})
 
 	provider := fakeDeliveryProvider{
-		date: "01-01-1970",
+		date: "2020-05-18",
 	}
 
-	bot := NewBot(&storage, &provider)
+	bot := NewBot(s, &provider)
 	bot.SetMessenger(fakeMessenger)
 
 	msg := domain.Message{
@@ -165,33 +261,558 @@ func TestBotMessageProcessor_ProcessCheck(t *testing.T) {
 	}
 
 	// Act
-	bot.DefaultMessageProcessor(msg)
+	bot.DefaultMessageProcessor(context.Background(), msg)
 
 	sentMsg := fakeMessenger.sentMessages[1]
-	assert.Contains(t, sentMsg, fmt.Sprintf("*%s*: %s-", provider.date, postcode))
+	assert.Contains(t, sentMsg, scheduleLine(provider.date, postcode))
 }
 
 func TestBotDelivery_Get(t *testing.T) {
 	fakeMessenger := newFakeMessenger()
-	postcode := "1234AA"
-	storage := fakeDataStorer{
-		subscriptions: map[domain.ChatID]domain.Subscription{
-			1: domain.Subscription{
-				ChatID:   1,
-				Postcode: postcode,
-			},
-		},
+	postcode := domain.Postcode("1234AA")
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{postcode},
+	})
+
+	provider := fakeDeliveryProvider{
+		date: "2020-05-18",
 	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+
+	// Act
+	bot.CheckDeliveries(context.Background())
+
+	sentMsg := fakeMessenger.sentMessages[1]
+	assert.Contains(t, sentMsg, scheduleLine(provider.date, postcode))
+}
+
+func TestBotDelivery_NotifyOnlyNewSlots(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	postcode := domain.Postcode("1234AA")
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{postcode},
+	})
 
 	provider := fakeDeliveryProvider{
-		date: "01-01-1970",
+		date: "2020-05-18",
 	}
-	bot := NewBot(&storage, &provider)
+	bot := NewBot(s, &provider)
 	bot.SetMessenger(fakeMessenger)
+	bot.CheckDeliveries(context.Background())
+	delete(fakeMessenger.sentMessages, 1)
 
 	// Act
-	bot.CheckDeliveries()
+	bot.CheckDeliveries(context.Background())
+
+	assert.Empty(t, fakeMessenger.sentMessages)
+
+	// Act
+	provider.date = "2020-05-19"
+	bot.CheckDeliveries(context.Background())
 
 	sentMsg := fakeMessenger.sentMessages[1]
-	assert.Contains(t, sentMsg, fmt.Sprintf("*%s*: %s-", provider.date, postcode))
+	assert.Contains(t, sentMsg, scheduleLine(provider.date, postcode))
+	assert.NotContains(t, sentMsg, formatDate("2020-05-18", defaultLanguage))
+}
+
+func TestBotDelivery_FirstCheckAfterStartIsBaseline(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	provider := fakeDeliveryProvider{date: "2020-05-18"}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+	published := []events.Event{}
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		published = append(published, event)
+		return nil
+	})
+
+	// Act
+	summary, err := bot.CheckDeliveries(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Notified)
+	assert.Empty(t, fakeMessenger.sentMessages)
+	assert.Empty(t, published)
+
+	// Act
+	provider.date = "2020-05-19"
+	summary, err = bot.CheckDeliveries(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Notified)
+	assert.Contains(t, fakeMessenger.sentMessages[1], scheduleLine("2020-05-19", "1234AA"))
+	assert.NotContains(t, fakeMessenger.sentMessages[1], formatDate("2020-05-18", defaultLanguage))
+}
+
+func TestBotDelivery_NotifyRemovedSlots(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	postcode := domain.Postcode("1234AA")
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{postcode},
+	})
+
+	provider := fakeDeliveryProvider{
+		date: "2020-05-18",
+	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	bot.SetNotifyRemoved(true)
+	bot.CheckDeliveries(context.Background())
+
+	// Act
+	provider.date = "2020-05-19"
+	bot.CheckDeliveries(context.Background())
+
+	sentMsg := fakeMessenger.sentMessages[1]
+	assert.Contains(t, sentMsg, "not available anymore")
+	assert.Contains(t, sentMsg, scheduleLine("2020-05-18", postcode))
+}
+
+func TestBotDelivery_NotifyInLanguageOfSubscription(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}, LanguageCode: "nl-NL"},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}},
+	)
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+
+	// Act
+	bot.CheckDeliveries(context.Background())
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "Nieuwe bezorgmomenten voor 1234AA")
+	assert.Contains(t, fakeMessenger.sentMessages[1], "ma 18 mei: 08:00-09:00")
+	assert.Contains(t, fakeMessenger.sentMessages[2], "New delivery slots for 1234AA")
+	assert.Contains(t, fakeMessenger.sentMessages[2], scheduleLine("2020-05-18", "1234AA"))
+}
+
+func TestBotMessageProcessor_ProcessCheckUnreachable(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+	})
+
+	provider := fakeDeliveryProvider{
+		err: &DeliveryError{Kind: ErrUnreachable, Postcode: "1234AA", Err: errors.New("timeout")},
+	}
+
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+
+	msg := domain.Message{
+		ChatID: 1,
+		Text:   "/check",
+	}
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), msg)
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "AH is unreachable, will retry")
+}
+
+func TestBotMessageProcessor_ProcessCheckPaused(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+	})
+	provider := fakeDeliveryProvider{
+		err: &DeliveryError{Kind: ErrChecksPaused, Postcode: "1234AA"},
+	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "AH checks are paused")
+}
+
+func TestBotMessageProcessor_ProcessCheckInvalidPayload(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+	})
+	provider := fakeDeliveryProvider{
+		err: &DeliveryError{Kind: ErrInvalidPayload, Postcode: "1234AA", Err: domain.ErrSlotMalformed},
+	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "Schedule of AH for 1234AA can't be read")
+	assert.NotContains(t, fakeMessenger.sentMessages[1], "unreachable")
+}
+
+func TestDeliveryErrorText(t *testing.T) {
+	testCases := []struct {
+		kind     error
+		language string
+		expected string
+	}{
+		{ErrUnknownPostcode, "en", "Postcode 1234AA is not known by AH. Try to register again with /addme 1234AB"},
+		{ErrUnknownPostcode, "nl", "Postcode 1234AA is niet bekend bij AH. Probeer opnieuw te registreren met /addme 1234AB"},
+		{ErrChecksPaused, "en", "AH checks are paused after repeated failures, will resume later"},
+		{ErrChecksPaused, "nl-NL", "AH controles zijn gepauzeerd na herhaalde fouten, ze worden later hervat"},
+		{ErrInvalidPayload, "nl", "Schema van AH voor 1234AA kan niet gelezen worden, wordt opnieuw geprobeerd"},
+		{ErrMisconfigured, "nl", "AH controles zijn niet beschikbaar door een probleem van de bot"},
+		{ErrUnreachable, "nl", "AH is onbereikbaar, wordt opnieuw geprobeerd"},
+		{ErrUnreachable, "fr", "AH is unreachable, will retry"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.kind.Error()+" "+tc.language, func(t *testing.T) {
+			err := &DeliveryError{Kind: tc.kind, Postcode: "1234AA"}
+
+			// Act
+			text := deliveryErrorText("1234AA", err, tc.language)
+
+			assert.Equal(t, tc.expected, text)
+		})
+	}
+}
+
+func TestBotMessageProcessor_ProcessCheckNotSubscribed(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	msg := domain.Message{
+		ChatID: 1,
+		Text:   "/check",
+	}
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), msg)
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "You are not subscribed")
+}
+
+func TestBotMessageProcessor_MultiplePostcodes(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage()
+	provider := fakeDeliveryProvider{
+		date: "2020-05-18",
+	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	ctx := context.Background()
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/addme 1234AA"})
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/addme 1234AB"})
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/list"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "1. 1234AA\n2. 1234AB")
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "1234AA\n"+scheduleLine("2020-05-18", "1234AA"))
+	assert.Contains(t, fakeMessenger.sentMessages[1], "1234AB\n"+scheduleLine("2020-05-18", "1234AB"))
+	assert.Contains(t, fakeMessenger.sentRich[1], domain.Bold("1234AA"))
+	assert.Contains(t, fakeMessenger.sentRich[1], domain.Bold("Mon 18 May"))
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/removeme 1234AA"})
+
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Postcode{"1234AB"}, sub.Postcodes)
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/removeme 1234AB"})
+
+	_, err = s.GetSubscriptionByID(ctx, 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestBotMessageProcessor_ProcessAddNormalizes(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/addme 1234 ab"})
+
+	sub, err := s.GetSubscriptionByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Postcode{"1234AB"}, sub.Postcodes)
+	assert.Contains(t, fakeMessenger.sentMessages[1], "1234AB")
+}
+
+func TestBotMessageProcessor_ProcessAddInvalid(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage()
+	bot := NewBot(s, &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/addme 0123AB"})
+
+	_, err := s.GetSubscriptionByID(context.Background(), 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+	assert.Contains(t, fakeMessenger.sentMessages[1], domain.ErrPostcodeLeadingZero.Error())
+}
+
+func TestBotDelivery_RequestPostcodeOnce(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB", "1234AA"}},
+		domain.Subscription{ChatID: 3, Postcodes: []domain.Postcode{"1234AA"}},
+	)
+	provider := countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+
+	// Act
+	bot.CheckDeliveries(context.Background())
+
+	assert.Equal(t, map[domain.Postcode]int{"1234AA": 1, "1234AB": 1}, provider.calls)
+	for _, chatID := range []domain.ChatID{1, 2, 3} {
+		assert.Contains(t, fakeMessenger.sentMessages[chatID], scheduleLine("2020-05-18", "1234AA"))
+	}
+	assert.Contains(t, fakeMessenger.sentMessages[2], scheduleLine("2020-05-18", "1234AB"))
+}
+
+type failingDeliveryProvider struct {
+	fakeDeliveryProvider
+	failing domain.Postcode
+}
+
+func (p *failingDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	if postcode == p.failing {
+		return nil, &DeliveryError{Kind: ErrUnreachable, Postcode: postcode}
+	}
+	return p.fakeDeliveryProvider.Get(ctx, postcode)
+}
+
+func TestBotDelivery_Summary(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA", "1234AB"}},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AC"}},
+	)
+	provider := failingDeliveryProvider{
+		fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"},
+		failing:              "1234AB",
+	}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+	bot.SetWorkers(3)
+
+	// Act
+	summary, err := bot.CheckDeliveries(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.CheckSummary{Postcodes: 3, Succeeded: 2, Failed: 1, Notified: 2}, summary)
+	assert.Contains(t, fakeMessenger.sentMessages[1], "1234AA")
+	assert.NotContains(t, fakeMessenger.sentMessages[1], "1234AB")
+	assert.Contains(t, fakeMessenger.sentMessages[2], "1234AC")
+}
+
+func TestBotDelivery_Cancelled(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA", "1234AB"}},
+	)
+	provider := countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	summary, err := bot.CheckDeliveries(ctx)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 2, summary.Postcodes)
+	assert.Equal(t, summary.Postcodes, summary.Succeeded+summary.Failed)
+}
+
+type recordingStorage struct {
+	storage.DataStorer
+	notified map[domain.ChatID]time.Time
+}
+
+func (s *recordingStorage) MarkNotified(ctx context.Context, chatID domain.ChatID, at time.Time) error {
+	s.notified[chatID] = at
+	return nil
+}
+
+func (s *recordingStorage) GetLastNotified(ctx context.Context, chatID domain.ChatID) (time.Time, error) {
+	return s.notified[chatID], nil
+}
+
+func TestBotEvents_CommandReceived(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(newTestStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+
+	// Act
+	err := bus.Publish(context.Background(), events.CommandReceived{Message: domain.Message{ChatID: 1, Text: "/addme 1234AA"}})
+
+	assert.NoError(t, err)
+	assert.Contains(t, fakeMessenger.sentMessages[1], "successful")
+}
+
+func TestBotEvents_ScanRequested(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := &recordingStorage{
+		DataStorer: newTestStorage(
+			domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}},
+			domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}},
+		),
+		notified: map[domain.ChatID]time.Time{},
+	}
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	now := time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC)
+	bot.now = func() time.Time { return now }
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+	published := []events.Event{}
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		if _, ok := event.(events.ScanRequested); !ok {
+			published = append(published, event)
+		}
+		return nil
+	})
+	summary := domain.CheckSummary{}
+
+	// Act
+	err := bus.Publish(context.Background(), events.ScanRequested{Postcode: "1234AA", Source: "test", Summary: &summary})
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.CheckSummary{Postcodes: 1, Succeeded: 1, Notified: 1}, summary)
+	assert.Len(t, published, 2)
+	assert.Equal(t, domain.Postcode("1234AA"), published[0].(events.ScheduleChanged).Postcode)
+	notification := published[1].(events.NotificationSent)
+	assert.Equal(t, domain.ChatID(1), notification.ChatID)
+	assert.Equal(t, []domain.Postcode{"1234AA"}, notification.Postcodes)
+	assert.Equal(t, now, notification.At)
+	assert.Equal(t, now, s.notified[1])
+	assert.NotContains(t, s.notified, domain.ChatID(2))
+}
+
+func TestBotDelivery_SendFailures(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	fakeMessenger.sendErrors = map[domain.ChatID]error{
+		2: &telegram.SendError{Kind: telegram.ErrChatUnavailable, ChatID: 2, Err: errors.New("Forbidden: bot was blocked by the user")},
+		3: &telegram.SendError{Kind: telegram.ErrSendFailed, ChatID: 3, Err: errors.New("Bad Request: message is too long")},
+	}
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{ChatID: 3, Postcodes: []domain.Postcode{"1234AA"}},
+	)
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+
+	// Act
+	summary, err := bot.CheckDeliveries(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, domain.CheckSummary{Postcodes: 1, Succeeded: 1, Notified: 1, NotificationsFailed: 1, Deactivated: 1}, summary)
+	_, err = s.GetSubscriptionByID(context.Background(), 2)
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+	_, err = s.GetSubscriptionByID(context.Background(), 3)
+	assert.NoError(t, err)
+}
+
+func TestBot_DeactivatedChatIsNotNotifiedAgain(t *testing.T) {
+	s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(newFakeMessenger())
+	subscription, err := s.GetSubscriptionByID(context.Background(), 1)
+	assert.NoError(t, err)
+	slots := map[domain.Postcode]DeliverySchedule{"1234AA": {"2020-05-18": {newTestSlot("2020-05-18", "08:00", "09:00", 0)}}}
+	unlock := bot.lockChat(1)
+	deactivated := make(chan struct{})
+
+	// Act
+	go func() {
+		bot.deactivate(context.Background(), subscription, &domain.CheckSummary{})
+		close(deactivated)
+	}()
+
+	// deactivation waits for the update of chat in progress
+	time.Sleep(20 * time.Millisecond)
+	_, err = s.GetSubscriptionByID(context.Background(), 1)
+	assert.NoError(t, err)
+	unlock()
+	<-deactivated
+	_, err = s.GetSubscriptionByID(context.Background(), 1)
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	// Act
+	bot.setNotifiedSlots(1, slots)
+
+	assert.Empty(t, bot.notifiedSlots(1), "check in progress has set slots of deactivated chat")
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/addme 1234AA"})
+	bot.setNotifiedSlots(1, slots)
+
+	assert.Equal(t, slots, bot.notifiedSlots(1))
+}
+
+func TestBot_SendSplitsLongMessage(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(storage.NewMemoryStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+	text := domain.RichText{}
+	for i := 0; i < 300; i++ {
+		text = append(text, domain.Bold(fmt.Sprintf("%03d 18-05-2020", i)), domain.Plain(": 08:00-10:00 10:00-12:00 🚚\n"))
+	}
+
+	// Act
+	err := bot.send(domain.Message{ChatID: 1, Rich: text, Broadcast: true})
+
+	assert.NoError(t, err)
+	assert.Len(t, fakeMessenger.sent, 4)
+	var sentText strings.Builder
+	for _, m := range fakeMessenger.sent {
+		assert.True(t, m.Broadcast)
+		assert.LessOrEqual(t, domain.UTF16Len(m.Rich.String()), telegram.MaxMessageLength)
+		sentText.WriteString(m.Rich.String())
+	}
+	assert.Equal(t, text.String(), sentText.String())
+	assert.True(t, strings.HasPrefix(fakeMessenger.sent[1].Rich.String(), "095 18-05-2020: "))
+}
+
+func TestBot_SendSplitsLongPlainText(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(storage.NewMemoryStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+	text := strings.Repeat("1234AB\n", 1000)
+
+	// Act
+	err := bot.send(domain.Message{ChatID: 1, Text: text})
+
+	assert.NoError(t, err)
+	assert.Len(t, fakeMessenger.sent, 2)
+	assert.Equal(t, text, fakeMessenger.sent[0].Text+fakeMessenger.sent[1].Text)
+	assert.Empty(t, fakeMessenger.sent[0].Rich)
 }
@@ -0,0 +1,91 @@
+package ahhelperbot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+type cachedSchedule struct {
+	schedule  DeliverySchedule
+	expiresAt time.Time
+}
+
+// pendingSchedule is a request of schedule in flight, done is closed when its result is set
+type pendingSchedule struct {
+	done     chan struct{}
+	schedule DeliverySchedule
+	err      error
+}
+
+// cachedDeliveryProvider reuses schedules received from provider for ttl
+type cachedDeliveryProvider struct {
+	provider DeliveryProvider
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	schedules map[domain.Postcode]cachedSchedule
+	pending   map[domain.Postcode]*pendingSchedule
+}
+
+// NewCachedDeliveryProvider returns provider which keeps schedules received from provider for ttl,
+// e.g. a manual check right after a scheduled one reuses the fresh schedule. Concurrent requests
+// of the same postcode share a single request of provider. Errors are not cached
+func NewCachedDeliveryProvider(provider DeliveryProvider, ttl time.Duration) DeliveryProvider {
+	return &cachedDeliveryProvider{
+		provider:  provider,
+		ttl:       ttl,
+		now:       time.Now,
+		schedules: map[domain.Postcode]cachedSchedule{},
+		pending:   map[domain.Postcode]*pendingSchedule{},
+	}
+}
+
+// Get returns cached schedule for postcode or requests it from the underlying provider.
+// While a request of postcode is in flight, Get waits for its result, including its error
+func (p *cachedDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	now := p.now()
+
+	p.mu.Lock()
+	cached, ok := p.schedules[postcode]
+	if ok && now.Before(cached.expiresAt) {
+		p.mu.Unlock()
+		return cached.schedule, nil
+	}
+	if pending, ok := p.pending[postcode]; ok {
+		p.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.schedule, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pending := &pendingSchedule{done: make(chan struct{})}
+	p.pending[postcode] = pending
+	p.mu.Unlock()
+
+	pending.schedule, pending.err = p.provider.Get(ctx, postcode)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, postcode)
+	close(pending.done)
+	if pending.err != nil {
+		return nil, pending.err
+	}
+	schedule := pending.schedule
+	p.schedules[postcode] = cachedSchedule{
+		schedule:  schedule,
+		expiresAt: now.Add(p.ttl),
+	}
+	for pc, s := range p.schedules {
+		if !now.Before(s.expiresAt) {
+			delete(p.schedules, pc)
+		}
+	}
+	return schedule, nil
+}
@@ -0,0 +1,132 @@
+package ahhelperbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingDeliveryProvider struct {
+	fakeDeliveryProvider
+	mu    sync.Mutex
+	calls map[domain.Postcode]int
+}
+
+func (p *countingDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	p.mu.Lock()
+	if p.calls == nil {
+		p.calls = map[domain.Postcode]int{}
+	}
+	p.calls[postcode]++
+	p.mu.Unlock()
+	return p.fakeDeliveryProvider.Get(ctx, postcode)
+}
+
+func TestCachedDeliveryProvider_Get(t *testing.T) {
+	provider := countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}}
+	now := time.Date(2020, 5, 18, 10, 0, 0, 0, time.UTC)
+	cached := NewCachedDeliveryProvider(&provider, time.Minute).(*cachedDeliveryProvider)
+	cached.now = func() time.Time { return now }
+
+	// Act
+	first, err := cached.Get(context.Background(), "1234AA")
+	assert.NoError(t, err)
+	now = now.Add(59 * time.Second)
+	second, err := cached.Get(context.Background(), "1234AA")
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, provider.calls["1234AA"])
+
+	// Act
+	now = now.Add(time.Second)
+	_, err = cached.Get(context.Background(), "1234AA")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, provider.calls["1234AA"])
+}
+
+func TestCachedDeliveryProvider_ErrorsAreNotCached(t *testing.T) {
+	provider := countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{err: errors.New("failed")}}
+	cached := NewCachedDeliveryProvider(&provider, time.Minute)
+
+	// Act
+	_, err1 := cached.Get(context.Background(), "1234AA")
+	_, err2 := cached.Get(context.Background(), "1234AA")
+
+	assert.Error(t, err1)
+	assert.Error(t, err2)
+	assert.Equal(t, 2, provider.calls["1234AA"])
+}
+
+// gatedDeliveryProvider answers requests after gate is closed
+type gatedDeliveryProvider struct {
+	countingDeliveryProvider
+	gate chan struct{}
+}
+
+func (p *gatedDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	<-p.gate
+	return p.countingDeliveryProvider.Get(ctx, postcode)
+}
+
+func TestCachedDeliveryProvider_ConcurrentRequestsAreShared(t *testing.T) {
+	provider := gatedDeliveryProvider{
+		countingDeliveryProvider: countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}},
+		gate:                     make(chan struct{}),
+	}
+	cached := NewCachedDeliveryProvider(&provider, time.Minute).(*cachedDeliveryProvider)
+	wg := sync.WaitGroup{}
+	schedules := make([]DeliverySchedule, 5)
+
+	// Act
+	for i := range schedules {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			schedule, err := cached.Get(context.Background(), "1234AA")
+			assert.NoError(t, err)
+			schedules[i] = schedule
+		}(i)
+	}
+	assert.Eventually(t, func() bool {
+		cached.mu.Lock()
+		defer cached.mu.Unlock()
+		return len(cached.pending) == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(provider.gate)
+	wg.Wait()
+
+	assert.Equal(t, 1, provider.calls["1234AA"])
+	for _, schedule := range schedules {
+		assert.Equal(t, schedules[0], schedule)
+	}
+}
+
+func TestCachedDeliveryProvider_WaitingRequestIsCancelled(t *testing.T) {
+	provider := gatedDeliveryProvider{
+		countingDeliveryProvider: countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}},
+		gate:                     make(chan struct{}),
+	}
+	defer close(provider.gate)
+	cached := NewCachedDeliveryProvider(&provider, time.Minute).(*cachedDeliveryProvider)
+	go cached.Get(context.Background(), "1234AA")
+	assert.Eventually(t, func() bool {
+		cached.mu.Lock()
+		defer cached.mu.Unlock()
+		return len(cached.pending) == 1
+	}, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, err := cached.Get(ctx, "1234AA")
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -1,6 +1,7 @@
 package ahhelperbot
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,56 +9,195 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"regexp"
-	"strings"
 	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/baor/ah-helper-bot/domain"
 )
 
 // DeliveryProvider defines provider schedule
 type DeliveryProvider interface {
-	Get(postcode string) DeliverySchedule
+	Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error)
+}
+
+// Kinds of delivery errors. Use errors.Is to check the kind of an error returned by DeliveryProvider
+var (
+	ErrUnreachable      = errors.New("AH is unreachable")
+	ErrUnexpectedStatus = errors.New("unexpected response status")
+	ErrRateLimited      = errors.New("rate limited by AH")
+	ErrInvalidPayload   = errors.New("invalid delivery payload")
+	ErrUnknownPostcode  = errors.New("unknown postcode")
+	ErrChecksPaused     = errors.New("AH checks are paused after repeated failures")
+	ErrMisconfigured    = errors.New("delivery provider is misconfigured")
+)
+
+// DeliveryError describes failed request of delivery schedule
+type DeliveryError struct {
+	// Kind is one of the Err* kinds of delivery errors
+	Kind       error
+	Postcode   domain.Postcode
+	StatusCode int
+	// RetryAfter is a delay requested by AH in Retry-After header
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("delivery request for postcode '%s': %v", e.Postcode, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the kind of the error
+func (e *DeliveryError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
 }
 
+const defaultDeliveryBaseURL = "https://www.ah.nl"
+
 // DefaultDeliveryProvider default implementation
-type DefaultDeliveryProvider struct{}
+type DefaultDeliveryProvider struct {
+	// Client is used for requests to AH. http.Client with 20 seconds timeout is used if nil
+	Client *http.Client
+	// BaseURL of AH. https://www.ah.nl is used if empty
+	BaseURL string
+	// Retry of transient failures. Failed request is not retried if MaxAttempts is not set
+	Retry RetryPolicy
+	// Breaker pauses all requests after repeated failures. Optional
+	Breaker *CircuitBreaker
+	// Limiter is waited before every attempt including retries. Optional
+	Limiter *rate.Limiter
+	// Location of dates and times of slots. domain.SlotTimezone is loaded on every request if nil,
+	// set it once on start instead. Failed loading is reported as ErrMisconfigured
+	Location *time.Location
+}
 
 // Get returns schedule for AH
-func (p *DefaultDeliveryProvider) Get(postcode string) DeliverySchedule {
+func (p *DefaultDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
 	log.Printf("Request deliveries for postcode '%s'", postcode)
 	if len(postcode) == 0 {
-		log.Print("Postcode is empty!")
-		return nil
+		return nil, &DeliveryError{Kind: ErrUnknownPostcode, Postcode: postcode}
 	}
 
-	c := http.Client{Timeout: 20 * time.Second}
+	location, err := p.location()
+	if err != nil {
+		return nil, &DeliveryError{Kind: ErrMisconfigured, Postcode: postcode, Err: err}
+	}
+
+	if p.Breaker != nil && !p.Breaker.Allow() {
+		return nil, &DeliveryError{Kind: ErrChecksPaused, Postcode: postcode}
+	}
 
-	req := newDeliveryRequest(postcode)
+	var schedule DeliverySchedule
+	err = p.Retry.Do(ctx, func() error {
+		if err := waitLimiter(ctx, p.Limiter); err != nil {
+			return err
+		}
+		var err error
+		schedule, err = p.get(ctx, postcode, location)
+		return err
+	})
+
+	if p.Breaker != nil {
+		switch {
+		case ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			// cancelled request says nothing about AH
+			p.Breaker.Release()
+		case err == nil:
+			p.Breaker.Success()
+		case isTransient(err):
+			p.Breaker.Failure()
+		default:
+			// AH has answered, but not with a schedule
+			p.Breaker.Release()
+		}
+	}
+	return schedule, err
+}
+
+// get makes a single request of schedule
+func (p *DefaultDeliveryProvider) get(ctx context.Context, postcode domain.Postcode, location *time.Location) (DeliverySchedule, error) {
+	c := p.Client
+	if c == nil {
+		c = &http.Client{Timeout: 20 * time.Second}
+	}
+	baseURL := p.BaseURL
+	if len(baseURL) == 0 {
+		baseURL = defaultDeliveryBaseURL
+	}
+
+	req, err := newDeliveryRequest(ctx, baseURL, postcode)
+	if err != nil {
+		return nil, &DeliveryError{Kind: ErrUnreachable, Postcode: postcode, Err: err}
+	}
 	dumpReq, _ := httputil.DumpRequest(req, false)
 	log.Printf("Request: %v\n", string(dumpReq))
 	resp, err := c.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &DeliveryError{Kind: ErrUnreachable, Postcode: postcode, Err: err}
 	}
+	defer resp.Body.Close()
 	dump, _ := httputil.DumpResponse(resp, true)
 	log.Printf("Response: %v\n", string(dump))
 
-	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, &DeliveryError{Kind: ErrRateLimited, Postcode: postcode, StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &DeliveryError{Kind: ErrUnknownPostcode, Postcode: postcode, StatusCode: resp.StatusCode}
+	case resp.StatusCode != http.StatusOK:
+		return nil, &DeliveryError{Kind: ErrUnexpectedStatus, Postcode: postcode, StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
 
 	dr := deliveryResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+		return nil, &DeliveryError{Kind: ErrInvalidPayload, Postcode: postcode, StatusCode: resp.StatusCode, Err: err}
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&dr)
+	schedule, err := convertResponseToSchedule(dr, location)
+	if err != nil {
+		return nil, &DeliveryError{Kind: ErrInvalidPayload, Postcode: postcode, StatusCode: resp.StatusCode, Err: err}
+	}
+	return schedule, nil
+}
 
-	return convertResponseToSchedule(dr)
+// location returns Location of provider or loads the timezone of AH
+func (p *DefaultDeliveryProvider) location() (*time.Location, error) {
+	if p.Location != nil {
+		return p.Location, nil
+	}
+	location, err := time.LoadLocation(domain.SlotTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone of slots %s: %w", domain.SlotTimezone, err)
+	}
+	return location, nil
 }
 
-func newDeliveryRequest(postcode string) *http.Request {
-	url := "https://www.ah.nl/service/rest/delegate?url=%2Fkies-moment%2Fbezorgen%2F" + postcode
-	req, _ := http.NewRequest("GET", url, nil)
+func newDeliveryRequest(ctx context.Context, baseURL string, postcode domain.Postcode) (*http.Request, error) {
+	url := baseURL + "/service/rest/delegate?url=%2Fkies-moment%2Fbezorgen%2F" + postcode.String()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("user-agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.162 Safari/537.36")
 	req.Header.Add("accept", "application/json, text/javascript, */*; q=0.01")
 	req.Header.Add("referer", "https://www.ah.nl/mijnlijst")
 
-	return req
+	return req, nil
 }
 
 // DeliveryTimeSlotBase base struct of delivery time slot. Same for time and date schedule
@@ -66,6 +206,8 @@ type DeliveryTimeSlotBase struct {
 	From  string `json:"from"`
 	To    string `json:"to"`
 	State string `json:"state"`
+	// Value is the price in euro
+	Value float64 `json:"value"`
 }
 
 type deliveryTimeSlot struct {
@@ -83,6 +225,7 @@ func (s *deliveryTimeSlot) UnmarshalJSON(data []byte) error {
 	s.From = base.From
 	s.To = base.To
 	s.State = base.State
+	s.Value = base.Value
 
 	var objmap map[string]json.RawMessage
 	if err := json.Unmarshal(data, &objmap); err != nil {
@@ -114,8 +257,11 @@ func (s *deliveryTimeSlot) UnmarshalJSON(data []byte) error {
 	}
 
 	r := regexp.MustCompile("/(\\d{4}-\\d{2}-\\d{2})/")
-	date := r.FindStringSubmatch(href)[1]
-	s.Date = date
+	match := r.FindStringSubmatch(href)
+	if match == nil {
+		return fmt.Errorf("no date in href '%s'", href)
+	}
+	s.Date = match[1]
 
 	return nil
 }
@@ -257,61 +403,101 @@ func (d *deliveryResponse) UnmarshalJSON(data []byte) error {
 }
 
 //DeliverySchedule is used to represent time schedule by date
-type DeliverySchedule map[string][]DeliveryTimeSlotBase
+type DeliverySchedule map[string][]domain.Slot
 
-//convertResponseToSchedule converts response to delivery schedule
-func convertResponseToSchedule(dr deliveryResponse) DeliverySchedule {
+//convertResponseToSchedule converts response to delivery schedule with slots in location.
+//Malformed slots are skipped, it fails only if there are slots and none of them can be read
+func convertResponseToSchedule(dr deliveryResponse, location *time.Location) (DeliverySchedule, error) {
 	ds := DeliverySchedule{}
+	var malformed error
+	add := func(date string, dts deliveryTimeSlot) {
+		slot, err := domain.NewSlot(domain.RawSlot{
+			Date:  date,
+			From:  dts.From,
+			To:    dts.To,
+			State: dts.State,
+			Dl:    dts.Dl,
+			Value: dts.Value,
+		}, location)
+		if err != nil {
+			// a single odd slot should not hide the rest of the schedule
+			malformed = fmt.Errorf("slot %s %s-%s: %w", date, dts.From, dts.To, err)
+			log.Printf("Skip %v", malformed)
+			return
+		}
+		ds[date] = append(ds[date], slot)
+	}
+
 	for _, line := range dr.lanes {
 		for _, item := range line.items {
 			for _, dd := range item.deliveryDates {
-				slots := []DeliveryTimeSlotBase{}
 				for _, dts := range dd.DeliveryTimeSlots {
-					if dts.State == "full" {
-						continue
-					}
-					slots = append(slots, DeliveryTimeSlotBase{
-						Dl:   dts.Dl,
-						From: dts.From,
-						To:   dts.To,
-					})
-
-				}
-				if len(slots) > 0 {
-					if ds[dd.Date] == nil {
-						ds[dd.Date] = []DeliveryTimeSlotBase{}
-					}
-					ds[dd.Date] = append(ds[dd.Date], slots...)
+					add(dd.Date, dts)
 				}
 			}
 			for _, dts := range item.deliveryTimeSlots {
-				if dts.State == "full" {
-					continue
-				}
+				add(dts.Date, dts)
+			}
+		}
+	}
+	if len(ds) == 0 && malformed != nil {
+		return nil, malformed
+	}
+	return ds, nil
+}
 
-				if ds[dts.Date] == nil {
-					ds[dts.Date] = []DeliveryTimeSlotBase{}
-				}
-				ds[dts.Date] = append(ds[dts.Date], DeliveryTimeSlotBase{
-					Dl:   dts.Dl,
-					From: dts.From,
-					To:   dts.To,
-				})
+// Available returns schedule without full slots
+func (ds DeliverySchedule) Available() DeliverySchedule {
+	res := DeliverySchedule{}
+	for date, slots := range ds {
+		for _, slot := range slots {
+			if slot.State == domain.SlotFull {
+				continue
+			}
+			res[date] = append(res[date], slot)
+		}
+	}
+	return res
+}
+
+// Filter returns slots of schedule accepted by filter, lead time is counted from now
+func (ds DeliverySchedule) Filter(filter domain.Filter, now time.Time) DeliverySchedule {
+	res := DeliverySchedule{}
+	for date, slots := range ds {
+		for _, slot := range slots {
+			if filter.Accepts(slot, now) {
+				res[date] = append(res[date], slot)
+			}
+		}
+	}
+	return res
+}
+
+// Diff returns slots which are in ds but not in prev as added
+// and slots which are in prev but not in ds as removed
+func (ds DeliverySchedule) Diff(prev DeliverySchedule) (added DeliverySchedule, removed DeliverySchedule) {
+	return ds.subtract(prev), prev.subtract(ds)
+}
 
+// subtract returns slots of ds which are not present in other
+func (ds DeliverySchedule) subtract(other DeliverySchedule) DeliverySchedule {
+	res := DeliverySchedule{}
+	for date, slots := range ds {
+		for _, slot := range slots {
+			if other.contains(date, slot) {
+				continue
 			}
+			res[date] = append(res[date], slot)
 		}
 	}
-	return ds
+	return res
 }
 
-func (ds DeliverySchedule) String() string {
-	var stringBuilder strings.Builder
-	for date, scheds := range ds {
-		stringBuilder.WriteString(fmt.Sprintf("*%s*: ", date))
-		for _, sched := range scheds {
-			stringBuilder.WriteString(fmt.Sprintf("%s-%s ", sched.From, sched.To))
+func (ds DeliverySchedule) contains(date string, slot domain.Slot) bool {
+	for _, s := range ds[date] {
+		if s.Start.Equal(slot.Start) && s.End.Equal(slot.End) {
+			return true
 		}
-		stringBuilder.WriteString("\n")
 	}
-	return stringBuilder.String()
+	return false
 }
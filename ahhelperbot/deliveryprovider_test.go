@@ -1,10 +1,17 @@
 package ahhelperbot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
 )
 
 func TestDeliveryProvider_Unmarshal_Items(t *testing.T) {
@@ -89,74 +96,45 @@ func TestDeliveryProvider_Unmarshal_Items(t *testing.T) {
 	assert.Equal(t, "18:00", dr.lanes[0].items[2].deliveryDates[0].DeliveryTimeSlots[0].To)
 }
 
-func TestDeliveryProvider_convertResponseToSchedule(t *testing.T) {
+// testLocation is the timezone of slots in tests
+var testLocation = mustLoadLocation(domain.SlotTimezone)
 
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// newTestSlot returns available slot at date from-to like 08:00-10:00
+func newTestSlot(date string, from string, to string, dl int) domain.Slot {
+	slot, err := domain.NewSlot(domain.RawSlot{Date: date, From: from, To: to, State: "selectable", Dl: dl}, testLocation)
+	if err != nil {
+		panic(err)
+	}
+	return slot
+}
+
+func TestDeliveryProvider_convertResponseToSchedule(t *testing.T) {
 	dr := deliveryResponse{
 		[]deliveryLane{
-			deliveryLane{
+			{
 				items: []item{
-					item{
+					{
 						deliveryDates: []deliveryDate{
-							deliveryDate{
-								Date: "d1-1",
+							{
+								Date: "2020-05-18",
 								DeliveryTimeSlots: []deliveryTimeSlot{
-									deliveryTimeSlot{
-										Date: "d1-1",
-										DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-											Dl:    1,
-											From:  "f1-1",
-											To:    "t1-1",
-											State: "s1-1",
-										},
-									},
-									deliveryTimeSlot{
-										Date: "d1-1",
-										DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-											Dl:    2,
-											From:  "f1-2",
-											To:    "t1-2",
-											State: "full",
-										},
-									},
-									deliveryTimeSlot{
-										Date: "d1-1",
-										DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-											Dl:    3,
-											From:  "f1-3",
-											To:    "t1-3",
-											State: "s1-3",
-										},
-									},
+									{DeliveryTimeSlotBase: DeliveryTimeSlotBase{Dl: 1, From: "08:00", To: "10:00", State: "selectable"}},
+									{DeliveryTimeSlotBase: DeliveryTimeSlotBase{Dl: 2, From: "10:00", To: "12:00", State: "full"}},
 								},
 							},
 						},
 						deliveryTimeSlots: []deliveryTimeSlot{
-							deliveryTimeSlot{
-								Date: "d2-1",
-								DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-									Dl:    4,
-									From:  "f2-1",
-									To:    "t2-1",
-									State: "s2-1",
-								},
-							},
-							deliveryTimeSlot{
-								Date: "d2-2",
-								DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-									Dl:    5,
-									From:  "f2-2",
-									To:    "t2-2",
-									State: "full",
-								},
-							},
-							deliveryTimeSlot{
-								Date: "d2-3",
-								DeliveryTimeSlotBase: DeliveryTimeSlotBase{
-									Dl:    5,
-									From:  "f2-3",
-									To:    "t2-3",
-									State: "s2-3",
-								},
+							{
+								Date:                 "2020-05-19",
+								DeliveryTimeSlotBase: DeliveryTimeSlotBase{Dl: 4, From: "18:00", To: "20:00", State: "selectable"},
 							},
 						},
 					},
@@ -166,17 +144,291 @@ func TestDeliveryProvider_convertResponseToSchedule(t *testing.T) {
 	}
 
 	// Act
-	ds := convertResponseToSchedule(dr)
-
-	assert.NotNil(t, ds)
-	assert.Equal(t, 2, len(ds["d1-1"]))
-	assert.Equal(t, "f1-1", ds["d1-1"][0].From)
-	assert.Equal(t, "t1-1", ds["d1-1"][0].To)
-	assert.Equal(t, "f1-3", ds["d1-1"][1].From)
-	assert.Equal(t, "t1-3", ds["d1-1"][1].To)
-
-	assert.Equal(t, "f2-1", ds["d2-1"][0].From)
-	assert.Equal(t, "t2-1", ds["d2-1"][0].To)
-	assert.Equal(t, "f2-3", ds["d2-3"][0].From)
-	assert.Equal(t, "t2-3", ds["d2-3"][0].To)
+	ds, err := convertResponseToSchedule(dr, testLocation)
+
+	assert.NoError(t, err)
+	assert.Len(t, ds["2020-05-18"], 2)
+	assert.Equal(t, time.Date(2020, 5, 18, 8, 0, 0, 0, testLocation), ds["2020-05-18"][0].Start)
+	assert.Equal(t, time.Date(2020, 5, 18, 10, 0, 0, 0, testLocation), ds["2020-05-18"][0].End)
+	assert.Equal(t, domain.SlotAvailable, ds["2020-05-18"][0].State)
+	assert.Equal(t, domain.SlotFull, ds["2020-05-18"][1].State)
+	assert.Equal(t, domain.RawSlot{Date: "2020-05-18", From: "10:00", To: "12:00", State: "full", Dl: 2}, ds["2020-05-18"][1].Raw)
+	assert.Equal(t, time.Date(2020, 5, 19, 18, 0, 0, 0, testLocation), ds["2020-05-19"][0].Start)
+	assert.Equal(t, 4, ds["2020-05-19"][0].Dl)
+
+	assert.Equal(t, DeliverySchedule{
+		"2020-05-18": {ds["2020-05-18"][0]},
+		"2020-05-19": ds["2020-05-19"],
+	}, ds.Available())
+}
+
+func TestDeliveryProvider_convertResponseToScheduleSkipsMalformed(t *testing.T) {
+	dr := deliveryResponse{[]deliveryLane{{items: []item{{
+		deliveryDates: []deliveryDate{{
+			Date: "2020-05-18",
+			DeliveryTimeSlots: []deliveryTimeSlot{
+				{DeliveryTimeSlotBase: DeliveryTimeSlotBase{From: "08:00", To: "1000"}},
+				{DeliveryTimeSlotBase: DeliveryTimeSlotBase{From: "10:00", To: "12:00", State: "selectable"}},
+			},
+		}},
+	}}}}}
+
+	// Act
+	ds, err := convertResponseToSchedule(dr, testLocation)
+
+	assert.NoError(t, err)
+	assert.Len(t, ds["2020-05-18"], 1)
+	assert.Equal(t, "10:00", ds["2020-05-18"][0].Raw.From)
+}
+
+func TestDeliveryProvider_convertResponseToScheduleMalformed(t *testing.T) {
+	dr := deliveryResponse{[]deliveryLane{{items: []item{{
+		deliveryDates: []deliveryDate{{
+			Date: "2020-05-18",
+			DeliveryTimeSlots: []deliveryTimeSlot{
+				{DeliveryTimeSlotBase: DeliveryTimeSlotBase{From: "08:00", To: "1000"}},
+			},
+		}},
+	}}}}}
+
+	// Act
+	_, err := convertResponseToSchedule(dr, testLocation)
+
+	assert.True(t, errors.Is(err, domain.ErrSlotMalformed), "unexpected error %v", err)
+}
+
+func TestDeliverySchedule_Diff(t *testing.T) {
+	prev := DeliverySchedule{
+		"2020-05-18": {
+			newTestSlot("2020-05-18", "08:00", "09:00", 1),
+			newTestSlot("2020-05-18", "09:00", "10:00", 1),
+		},
+		"2020-05-19": {
+			newTestSlot("2020-05-19", "08:00", "09:00", 1),
+		},
+	}
+	current := DeliverySchedule{
+		"2020-05-18": {
+			newTestSlot("2020-05-18", "09:00", "10:00", 1),
+			newTestSlot("2020-05-18", "10:00", "11:00", 1),
+		},
+		"2020-05-20": {
+			newTestSlot("2020-05-20", "08:00", "09:00", 1),
+		},
+	}
+
+	// Act
+	added, removed := current.Diff(prev)
+
+	assert.Equal(t, DeliverySchedule{
+		"2020-05-18": {newTestSlot("2020-05-18", "10:00", "11:00", 1)},
+		"2020-05-20": {newTestSlot("2020-05-20", "08:00", "09:00", 1)},
+	}, added)
+	assert.Equal(t, DeliverySchedule{
+		"2020-05-18": {newTestSlot("2020-05-18", "08:00", "09:00", 1)},
+		"2020-05-19": {newTestSlot("2020-05-19", "08:00", "09:00", 1)},
+	}, removed)
+}
+
+func TestDefaultDeliveryProvider_Get(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/kies-moment/bezorgen/1234AA", r.URL.Query().Get("url"))
+		w.Write([]byte(`{"_embedded": {"lanes": [{"_embedded": {"items": [{
+			"type": "DeliveryDateSelector",
+			"_embedded": {"deliveryDates": [{"date": "2020-04-06", "deliveryTimeSlots": [
+				{"dl": 1, "from": "16:00", "state": "selectable", "to": "18:00"}
+			]}]}
+		}]}}]}}`))
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL}
+
+	// Act
+	ds, err := p.Get(context.Background(), "1234AA")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "16:00", ds["2020-04-06"][0].Raw.From)
+	assert.Equal(t, time.Date(2020, 4, 6, 14, 0, 0, 0, time.UTC), ds["2020-04-06"][0].Start.UTC())
+}
+
+func TestDefaultDeliveryProvider_GetMalformedSlot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"_embedded": {"lanes": [{"_embedded": {"items": [{
+			"type": "DeliveryDateSelector",
+			"_embedded": {"deliveryDates": [{"date": "2020-04-06", "deliveryTimeSlots": [
+				{"dl": 1, "from": "16:00", "state": "selectable", "to": "4pm"}
+			]}]}
+		}]}}]}}`))
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL}
+
+	// Act
+	_, err := p.Get(context.Background(), "1234AA")
+
+	assert.True(t, errors.Is(err, ErrInvalidPayload), "unexpected error %v", err)
+	assert.True(t, errors.Is(err, domain.ErrSlotMalformed), "unexpected error %v", err)
+}
+
+func TestDefaultDeliveryProvider_GetErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+		body   string
+		kind   error
+	}{
+		{"rate limited", http.StatusTooManyRequests, "", ErrRateLimited},
+		{"unknown postcode", http.StatusNotFound, "", ErrUnknownPostcode},
+		{"unexpected status", http.StatusBadGateway, "", ErrUnexpectedStatus},
+		{"invalid payload", http.StatusOK, "<html>", ErrInvalidPayload},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			p := DefaultDeliveryProvider{BaseURL: server.URL}
+
+			// Act
+			_, err := p.Get(context.Background(), "1234AA")
+
+			assert.True(t, errors.Is(err, tc.kind), "unexpected error %v", err)
+			var deliveryErr *DeliveryError
+			assert.True(t, errors.As(err, &deliveryErr))
+			assert.Equal(t, tc.status, deliveryErr.StatusCode)
+		})
+	}
+}
+
+func TestDefaultDeliveryProvider_GetUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL}
+
+	// Act
+	_, err := p.Get(context.Background(), "1234AA")
+
+	assert.True(t, errors.Is(err, ErrUnreachable), "unexpected error %v", err)
+}
+
+func TestDefaultDeliveryProvider_GetRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if calls == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"_embedded": {"lanes": []}}`))
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{
+		BaseURL: server.URL,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	}
+
+	// Act
+	_, err := p.Get(context.Background(), "1234AA")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDefaultDeliveryProvider_GetRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL}
+
+	// Act
+	_, err := p.Get(context.Background(), "1234AA")
+
+	var deliveryErr *DeliveryError
+	assert.True(t, errors.As(err, &deliveryErr))
+	assert.Equal(t, 2*time.Minute, deliveryErr.RetryAfter)
+}
+
+func TestDefaultDeliveryProvider_GetPausedByBreaker(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	breaker := NewCircuitBreaker(2, time.Hour)
+	p := DefaultDeliveryProvider{BaseURL: server.URL, Breaker: breaker}
+	p.Get(context.Background(), "1234AA")
+	p.Get(context.Background(), "1234AA")
+
+	// Act
+	_, err := p.Get(context.Background(), "1234AA")
+
+	assert.True(t, errors.Is(err, ErrChecksPaused), "unexpected error %v", err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestDefaultDeliveryProvider_UnknownPostcodeDoesNotOpenBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	breaker := NewCircuitBreaker(1, time.Hour)
+	p := DefaultDeliveryProvider{BaseURL: server.URL, Breaker: breaker}
+
+	// Act
+	p.Get(context.Background(), "1234AA")
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestDefaultDeliveryProvider_InvalidPayloadIsNotSuccess(t *testing.T) {
+	status := http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte("<html>maintenance</html>"))
+	}))
+	defer server.Close()
+	breaker := NewCircuitBreaker(2, time.Hour)
+	p := DefaultDeliveryProvider{BaseURL: server.URL, Breaker: breaker}
+	p.Get(context.Background(), "1234AA")
+	status = http.StatusOK
+	p.Get(context.Background(), "1234AA")
+	status = http.StatusBadGateway
+
+	// Act
+	p.Get(context.Background(), "1234AA")
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestDefaultDeliveryProvider_CancelledRequestIsNotFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	breaker := NewCircuitBreaker(1, time.Hour)
+	p := DefaultDeliveryProvider{
+		BaseURL: server.URL,
+		Retry:   RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour},
+		Breaker: breaker,
+	}
+	// the context is done while waiting for retry
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, err := p.Get(ctx, "1234AA")
+
+	assert.Error(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State())
 }
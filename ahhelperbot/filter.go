@@ -0,0 +1,142 @@
+package ahhelperbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// filterUsage describes arguments of /filter
+const filterUsage = "/filter weekdays mo-fr 18:00-22:00, /filter time 18:00-22:00, /filter lead 2h-3d, " +
+	"/filter price 5.95, /filter show or /filter clear. Remove a single filter with any, e.g. /filter lead any"
+
+// errFilterUsage is returned for unknown kind of filter
+var errFilterUsage = errors.New("unknown filter")
+
+// anyValue removes a filter of the kind
+const anyValue = "any"
+
+// applyFilterArgs returns filter changed by arguments of /filter like "weekdays mo-fr 18:00-22:00"
+// or "clear". Other kinds of filter are kept
+func applyFilterArgs(filter domain.Filter, args string) (domain.Filter, error) {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		return filter, errFilterUsage
+	}
+	kind, values := fields[0], fields[1:]
+	if kind == "clear" && len(values) == 0 {
+		return domain.Filter{}, nil
+	}
+	if len(values) == 0 {
+		return filter, fmt.Errorf("filter %s requires a value", kind)
+	}
+	removed := len(values) == 1 && values[0] == anyValue
+
+	switch kind {
+	case "weekdays":
+		if removed {
+			filter.Weekdays = nil
+			return filter, nil
+		}
+		weekdays, err := domain.ParseWeekdays(values[0])
+		if err != nil {
+			return filter, err
+		}
+		next := filter
+		next.Weekdays = weekdays
+		if len(values) > 1 {
+			if next, err = applyWindows(next, values[1:]); err != nil {
+				return filter, err
+			}
+		}
+		return next, nil
+	case "time":
+		if removed {
+			filter.Windows = nil
+			return filter, nil
+		}
+		return applyWindows(filter, values)
+	case "lead":
+		if removed {
+			filter.MinLead, filter.MaxLead = 0, 0
+			return filter, nil
+		}
+		if len(values) != 1 {
+			return filter, domain.ErrFilterLead
+		}
+		min, max, err := domain.ParseLead(values[0])
+		if err != nil {
+			return filter, err
+		}
+		filter.MinLead, filter.MaxLead = min, max
+		return filter, nil
+	case "price":
+		if removed {
+			filter.MaxPrice = 0
+			return filter, nil
+		}
+		if len(values) != 1 {
+			return filter, domain.ErrFilterPrice
+		}
+		price, err := domain.ParsePrice(values[0])
+		if err != nil {
+			return filter, err
+		}
+		filter.MaxPrice = price
+		return filter, nil
+	}
+	return filter, errFilterUsage
+}
+
+// applyWindows replaces time windows of filter
+func applyWindows(filter domain.Filter, values []string) (domain.Filter, error) {
+	windows := []domain.TimeWindow{}
+	for _, value := range values {
+		w, err := domain.ParseTimeWindow(value)
+		if err != nil {
+			return filter, err
+		}
+		windows = append(windows, w)
+	}
+	filter.Windows = windows
+	return filter, nil
+}
+
+// setFilter changes filter of the chat subscription by arguments of /filter and shows the result
+func (b *Bot) setFilter(ctx context.Context, c domain.ChatID, args string, language string) {
+	defer b.lockChat(c)()
+	sub, ok := b.getSubscription(ctx, c)
+	if !ok {
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(args), "show") {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   fmt.Sprintf("Your filters: %s", sub.Filter)})
+		return
+	}
+
+	filter, err := applyFilterArgs(sub.Filter, args)
+	if err != nil {
+		b.send(domain.Message{
+			ChatID: c,
+			Text:   fmt.Sprintf("Filter '%s' is not valid: %v. Use %s", args, err, filterUsage)})
+		return
+	}
+
+	sub.Filter = filter
+	rememberLanguage(&sub, language)
+	log.Printf("message processor set filter: %+v", sub)
+	if err := b.storage.AddSubscription(ctx, sub); err != nil {
+		log.Printf("failed to set filter of subscription %+v: %v", sub, err)
+		b.sendMessageFailure(c)
+		return
+	}
+	b.send(domain.Message{
+		ChatID: c,
+		Text:   fmt.Sprintf("Your filters: %s", filter)})
+}
@@ -0,0 +1,250 @@
+package ahhelperbot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+func TestApplyFilterArgs(t *testing.T) {
+	workdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	evening := []domain.TimeWindow{{Start: 18 * time.Hour, End: 22 * time.Hour}}
+	current := domain.Filter{Weekdays: []time.Weekday{time.Saturday}, MaxPrice: 5.95}
+	testCases := []struct {
+		args     string
+		expected domain.Filter
+		err      bool
+	}{
+		{"weekdays mo-fr 18:00-22:00", domain.Filter{Weekdays: workdays, Windows: evening, MaxPrice: 5.95}, false},
+		{"weekdays any", domain.Filter{MaxPrice: 5.95}, false},
+		{"time 18:00-22:00", domain.Filter{Weekdays: []time.Weekday{time.Saturday}, Windows: evening, MaxPrice: 5.95}, false},
+		{"lead -3d", domain.Filter{Weekdays: []time.Weekday{time.Saturday}, MaxLead: 72 * time.Hour, MaxPrice: 5.95}, false},
+		{"price 4.95", domain.Filter{Weekdays: []time.Weekday{time.Saturday}, MaxPrice: 4.95}, false},
+		{"price any", domain.Filter{Weekdays: []time.Weekday{time.Saturday}}, false},
+		{"clear", domain.Filter{}, false},
+		{"weekdays", current, true},
+		{"weekdays mo-fr 18-22", current, true},
+		{"lead 2h 3d", current, true},
+		{"price free", current, true},
+		{"postcode 1234AB", current, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.args, func(t *testing.T) {
+			// Act
+			filter, err := applyFilterArgs(current, tc.args)
+
+			assert.Equal(t, tc.err, err != nil, "unexpected error %v", err)
+			assert.Equal(t, tc.expected, filter)
+		})
+	}
+}
+
+func TestBotMessageProcessor_ProcessFilter(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	ctx := context.Background()
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/filter weekdays mo-fr 18:00-22:00"})
+
+	assert.Equal(t, "Your filters: weekdays mo,tu,we,th,fr; time 18:00-22:00", fakeMessenger.sentMessages[1])
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TimeWindow{{Start: 18 * time.Hour, End: 22 * time.Hour}}, sub.Filter.Windows)
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "No deliveries available for 1234AA match your filters")
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/filter lead 1d"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "Filter 'lead 1d' is not valid")
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/filter clear"})
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/filter show"})
+
+	assert.Equal(t, "Your filters: no filters", fakeMessenger.sentMessages[1])
+	sub, err = s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, sub.Filter.IsEmpty())
+}
+
+func TestBotMessageProcessor_ProcessCheckShowsPrice(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+		Filter:    domain.Filter{MaxPrice: 5.95},
+	})
+	provider := fakeDeliveryProvider{date: "2020-05-18", price: 4.95}
+	bot := NewBot(s, &provider)
+	bot.SetMessenger(fakeMessenger)
+	ctx := context.Background()
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], scheduleLine("2020-05-18", "1234AA")+" €4.95")
+
+	// Act
+	provider.price = 6.95
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/check"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "No deliveries available for 1234AA match your filters: price 5.95")
+}
+
+// emptyDeliveryProvider returns schedules without slots
+type emptyDeliveryProvider struct{}
+
+func (emptyDeliveryProvider) Get(ctx context.Context, postcode domain.Postcode) (DeliverySchedule, error) {
+	return DeliverySchedule{}, nil
+}
+
+func TestBotMessageProcessor_ProcessCheckNoDeliveriesInLanguage(t *testing.T) {
+	testCases := []struct {
+		language string
+		filter   domain.Filter
+		expected string
+	}{
+		{"en", domain.Filter{}, "No deliveries available for 1234AA"},
+		{"nl", domain.Filter{}, "Geen bezorgmomenten beschikbaar voor 1234AA"},
+		{"en", domain.Filter{MaxPrice: 5.95}, "No deliveries available for 1234AA match your filters: price 5.95"},
+		{"nl-NL", domain.Filter{MaxPrice: 5.95}, "Geen beschikbare bezorgmomenten voor 1234AA passen bij je filters: price 5.95"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.language+" "+tc.filter.String(), func(t *testing.T) {
+			fakeMessenger := newFakeMessenger()
+			s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}, Filter: tc.filter})
+			var provider DeliveryProvider = &fakeDeliveryProvider{date: "2020-05-18", price: 6.95}
+			if tc.filter.IsEmpty() {
+				provider = emptyDeliveryProvider{}
+			}
+			bot := NewBot(s, provider)
+			bot.SetMessenger(fakeMessenger)
+
+			// Act
+			bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/check", LanguageCode: tc.language})
+
+			assert.Contains(t, fakeMessenger.sentMessages[1], tc.expected)
+		})
+	}
+}
+
+func TestBotMessageProcessor_ProcessFilterNotSubscribed(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(newTestStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/filter price 5"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "You are not subscribed")
+}
+
+func TestBotDelivery_NotifyFilteredSlots(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(
+		domain.Subscription{
+			ChatID:    1,
+			Postcodes: []domain.Postcode{"1234AA"},
+			// 2020-05-18 is Monday
+			Filter: domain.Filter{Weekdays: []time.Weekday{time.Saturday, time.Sunday}},
+		},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{
+			ChatID:    3,
+			Postcodes: []domain.Postcode{"1234AA"},
+			Filter:    domain.Filter{MaxLead: 24 * time.Hour},
+		},
+	)
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	markChecked(t, bot)
+	bot.now = func() time.Time { return time.Date(2020, 5, 17, 12, 0, 0, 0, testLocation) }
+
+	// Act
+	summary, err := bot.CheckDeliveries(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Notified)
+	assert.NotContains(t, fakeMessenger.sentMessages, domain.ChatID(1))
+	assert.Contains(t, fakeMessenger.sentMessages[2], scheduleLine("2020-05-18", "1234AA"))
+	assert.Contains(t, fakeMessenger.sentMessages[3], scheduleLine("2020-05-18", "1234AA"))
+}
+
+func TestBotDelivery_NotifySlotEnteringLeadTime(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+		Filter:    domain.Filter{MaxLead: 24 * time.Hour},
+	})
+	// the slot of 1234AA starts on 2020-05-18 08:00
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	now := time.Date(2020, 5, 16, 12, 0, 0, 0, testLocation)
+	bot.now = func() time.Time { return now }
+	ctx := context.Background()
+	summary, err := bot.CheckDeliveries(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Notified)
+
+	// Act
+	now = now.Add(12 * time.Hour)
+	summary, err = bot.CheckDeliveries(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Notified)
+
+	// Act
+	now = now.Add(12 * time.Hour)
+	summary, err = bot.CheckDeliveries(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Notified)
+	assert.Contains(t, fakeMessenger.sentMessages[1], scheduleLine("2020-05-18", "1234AA"))
+
+	// Act
+	delete(fakeMessenger.sentMessages, 1)
+	now = now.Add(time.Hour)
+	summary, err = bot.CheckDeliveries(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Notified)
+	assert.Empty(t, fakeMessenger.sentMessages)
+}
+
+func TestBotDelivery_NotifySeenSlotsAfterFilterChange(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	s := newTestStorage(domain.Subscription{
+		ChatID:    1,
+		Postcodes: []domain.Postcode{"1234AA"},
+		// 2020-05-18 is Monday
+		Filter: domain.Filter{Weekdays: []time.Weekday{time.Saturday}},
+	})
+	bot := NewBot(s, &fakeDeliveryProvider{date: "2020-05-18"})
+	bot.SetMessenger(fakeMessenger)
+	bot.now = func() time.Time { return time.Date(2020, 5, 17, 12, 0, 0, 0, testLocation) }
+	ctx := context.Background()
+	summary, err := bot.CheckDeliveries(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Notified)
+
+	// Act
+	bot.DefaultMessageProcessor(ctx, domain.Message{ChatID: 1, Text: "/filter weekdays mo-fr"})
+	delete(fakeMessenger.sentMessages, 1)
+	summary, err = bot.CheckDeliveries(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Notified)
+	assert.Contains(t, fakeMessenger.sentMessages[1], scheduleLine("2020-05-18", "1234AA"))
+}
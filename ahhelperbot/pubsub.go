@@ -0,0 +1,127 @@
+package ahhelperbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
+	"github.com/baor/ah-helper-bot/storage"
+)
+
+// pubSubPushEnvelope is the body of Pub/Sub push request. Please refer to
+// https://cloud.google.com/pubsub/docs/push for additional information regarding Pub/Sub events.
+type pubSubPushEnvelope struct {
+	Message      pubSubMessage `json:"message"`
+	Subscription string        `json:"subscription"`
+}
+
+// pubSubMessage is the payload of a Pub/Sub event. Data is base64 encoded in JSON
+type pubSubMessage struct {
+	ID         string            `json:"messageId"`
+	Data       []byte            `json:"data"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// scanRequest is the data of Pub/Sub message. Empty request checks all subscriptions,
+// otherwise either a single postcode or a single chat is checked
+type scanRequest struct {
+	Postcode string        `json:"postcode"`
+	ChatID   domain.ChatID `json:"chat_id"`
+}
+
+// pubSubHandler publishes scan requests pushed by Pub/Sub on the event bus
+type pubSubHandler struct {
+	bus *events.Bus
+}
+
+// NewPubSubHandler returns handler of Pub/Sub push requests with scan requests.
+// Malformed messages are rejected with 400 and should go to a dead-letter topic,
+// failed checks respond with 500, so Pub/Sub redelivers the message
+func NewPubSubHandler(bus *events.Bus) http.Handler {
+	return &pubSubHandler{bus: bus}
+}
+
+func (h *pubSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var envelope pubSubPushEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		log.Printf("pubsub: invalid push request: %v", err)
+		http.Error(w, fmt.Sprintf("invalid push request: %v", err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("pubsub: message %s from %s is received", envelope.Message.ID, envelope.Subscription)
+
+	request, err := parseScanRequest(envelope.Message.Data)
+	if err != nil {
+		log.Printf("pubsub: invalid message %s: %v", envelope.Message.ID, err)
+		http.Error(w, fmt.Sprintf("invalid message: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var summary domain.CheckSummary
+	err = h.bus.Publish(r.Context(), events.ScanRequested{
+		Postcode: domain.Postcode(request.Postcode),
+		ChatID:   request.ChatID,
+		Source:   "pubsub",
+		Summary:  &summary,
+	})
+
+	if errors.Is(err, storage.ErrNotFound) {
+		// the chat has unsubscribed after the request was published, redelivery does not help
+		log.Printf("pubsub: message %s: %v", envelope.Message.ID, err)
+		fmt.Fprintf(w, "chat %s is not subscribed", request.ChatID)
+		return
+	}
+	if err == nil && summary.Failed > 0 && summary.Failed == summary.Paused {
+		// AH is not requested while checks are paused, the subscription retries with its backoff
+		log.Printf("pubsub: message %s is postponed, checks are paused: %s", envelope.Message.ID, summary)
+		http.Error(w, fmt.Sprintf("checks are paused: %s", summary), http.StatusServiceUnavailable)
+		return
+	}
+	if err == nil && summary.Failed > 0 {
+		// redelivery scans all postcodes again, chats are not notified twice about the same slots
+		err = fmt.Errorf("%d of %d postcodes failed", summary.Failed, summary.Postcodes)
+	}
+	if err != nil {
+		log.Printf("pubsub: message %s failed: %v, %s", envelope.Message.ID, err, summary)
+		http.Error(w, fmt.Sprintf("check failed: %s", summary), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("pubsub: message %s is done: %s", envelope.Message.ID, summary)
+	fmt.Fprintf(w, "check is done: %s", summary)
+}
+
+// parseScanRequest decodes and validates data of Pub/Sub message. Empty data requests check of all subscriptions
+func parseScanRequest(data []byte) (scanRequest, error) {
+	request := scanRequest{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return request, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return request, fmt.Errorf("invalid scan request: %w", err)
+	}
+	if len(request.Postcode) > 0 && request.ChatID != 0 {
+		return request, errors.New("scan request has both postcode and chat_id")
+	}
+	if len(request.Postcode) > 0 {
+		postcode, err := domain.ParsePostcode(request.Postcode)
+		if err != nil {
+			return request, err
+		}
+		request.Postcode = postcode.String()
+	}
+	return request, nil
+}
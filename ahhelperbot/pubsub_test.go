@@ -0,0 +1,136 @@
+package ahhelperbot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
+)
+
+func newPubSubTestBus(t *testing.T) (*events.Bus, *countingDeliveryProvider, *fakeMessenger) {
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA", "1234AB"}},
+		domain.Subscription{ChatID: 3, Postcodes: []domain.Postcode{"5678CD"}},
+	)
+	provider := &countingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}}
+	messenger := newFakeMessenger()
+	bot := NewBot(s, provider)
+	bot.SetMessenger(messenger)
+	markChecked(t, bot)
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+	return bus, provider, messenger
+}
+
+func pushRecorded(t *testing.T, handler http.Handler, ctx context.Context, name string) *httptest.ResponseRecorder {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "pubsub", name))
+	assert.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/pubsub", bytes.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestPubSubHandler_RecordedPayloads(t *testing.T) {
+	testCases := []struct {
+		file      string
+		status    int
+		requested []domain.Postcode
+		notified  []domain.ChatID
+	}{
+		{"all.json", http.StatusOK, []domain.Postcode{"1234AA", "1234AB", "5678CD"}, []domain.ChatID{1, 2, 3}},
+		{"all_empty_object.json", http.StatusOK, []domain.Postcode{"1234AA", "1234AB", "5678CD"}, []domain.ChatID{1, 2, 3}},
+		{"postcode.json", http.StatusOK, []domain.Postcode{"1234AA"}, []domain.ChatID{1, 2}},
+		{"chat.json", http.StatusOK, []domain.Postcode{"1234AA"}, []domain.ChatID{1, 2}},
+		{"unknown_chat.json", http.StatusOK, nil, nil},
+		{"invalid_base64.json", http.StatusBadRequest, nil, nil},
+		{"invalid_json.json", http.StatusBadRequest, nil, nil},
+		{"invalid_postcode.json", http.StatusBadRequest, nil, nil},
+		{"postcode_and_chat.json", http.StatusBadRequest, nil, nil},
+		{"malformed_envelope.json", http.StatusBadRequest, nil, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			bus, provider, messenger := newPubSubTestBus(t)
+
+			// Act
+			w := pushRecorded(t, NewPubSubHandler(bus), context.Background(), tc.file)
+
+			assert.Equal(t, tc.status, w.Code, w.Body.String())
+			requested := []domain.Postcode{}
+			for postcode := range provider.calls {
+				requested = append(requested, postcode)
+			}
+			assert.ElementsMatch(t, tc.requested, requested)
+			notified := []domain.ChatID{}
+			for chatID := range messenger.sentMessages {
+				notified = append(notified, chatID)
+			}
+			assert.ElementsMatch(t, tc.notified, notified)
+		})
+	}
+}
+
+func TestPubSubHandler_FailedCheckIsRedelivered(t *testing.T) {
+	bus, _, _ := newPubSubTestBus(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	w := pushRecorded(t, NewPubSubHandler(bus), ctx, "all.json")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestPubSubHandler_FailedPostcodeIsRedelivered(t *testing.T) {
+	s := newTestStorage(
+		domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}},
+		domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}},
+	)
+	provider := &failingDeliveryProvider{fakeDeliveryProvider: fakeDeliveryProvider{date: "2020-05-18"}, failing: "1234AB"}
+	bot := NewBot(s, provider)
+	bot.SetMessenger(newFakeMessenger())
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+
+	// Act
+	w := pushRecorded(t, NewPubSubHandler(bus), context.Background(), "all.json")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "check failed")
+}
+
+func TestPubSubHandler_PausedChecksAreUnavailable(t *testing.T) {
+	s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	provider := &fakeDeliveryProvider{err: &DeliveryError{Kind: ErrChecksPaused, Postcode: "1234AA"}}
+	bot := NewBot(s, provider)
+	bot.SetMessenger(newFakeMessenger())
+	bus := events.NewBus()
+	bot.SetEventBus(bus)
+
+	// Act
+	w := pushRecorded(t, NewPubSubHandler(bus), context.Background(), "all.json")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "checks are paused")
+}
+
+func TestPubSubHandler_MethodNotAllowed(t *testing.T) {
+	bus, _, _ := newPubSubTestBus(t)
+	r := httptest.NewRequest(http.MethodGet, "/pubsub", nil)
+	w := httptest.NewRecorder()
+
+	// Act
+	NewPubSubHandler(bus).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,21 @@
+package ahhelperbot
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// NewRequestLimiter returns limiter of at most rps requests per second to AH.
+// The limit is global for all goroutines using the returned limiter
+func NewRequestLimiter(rps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(rps), 1)
+}
+
+// waitLimiter waits until limiter allows a request. Nil limiter allows all requests
+func waitLimiter(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
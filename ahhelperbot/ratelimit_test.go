@@ -0,0 +1,73 @@
+package ahhelperbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultDeliveryProvider_LimitsRequests(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"_embedded":{"lanes":[]}}`))
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL, Limiter: NewRequestLimiter(20)}
+
+	// Act
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := p.Get(context.Background(), "1234AA")
+		assert.NoError(t, err)
+	}
+
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, "requests were not limited")
+	assert.Equal(t, 3, calls)
+}
+
+func TestDefaultDeliveryProvider_LimitsRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{
+		BaseURL: server.URL,
+		Retry:   RetryPolicy{MaxAttempts: 3},
+		Limiter: NewRequestLimiter(20),
+	}
+
+	// Act
+	start := time.Now()
+	_, err := p.Get(context.Background(), "1234AA")
+
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, "retries were not limited")
+	assert.Equal(t, 3, calls)
+}
+
+func TestDefaultDeliveryProvider_LimiterCancelled(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"_embedded":{"lanes":[]}}`))
+	}))
+	defer server.Close()
+	p := DefaultDeliveryProvider{BaseURL: server.URL, Limiter: NewRequestLimiter(0.001)}
+	_, err := p.Get(context.Background(), "1234AA")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, err = p.Get(ctx, "1234AA")
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
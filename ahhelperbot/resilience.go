@@ -0,0 +1,220 @@
+package ahhelperbot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries transient failures with jittered exponential backoff
+type RetryPolicy struct {
+	// MaxAttempts including the first one. Zero or one means no retries
+	MaxAttempts int
+	// BaseDelay is the maximum delay before the first retry, it doubles with every attempt
+	BaseDelay time.Duration
+	// MaxDelay limits backoff delay. If AH asks to retry later than MaxDelay, the request is not retried
+	MaxDelay time.Duration
+}
+
+// Do calls f until it succeeds, fails with not transient error, attempts are over or ctx is done.
+// The last error of f is returned
+func (r RetryPolicy) Do(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || !isTransient(err) || attempt >= r.MaxAttempts {
+			return err
+		}
+
+		delay, ok := r.delay(attempt, err)
+		if !ok {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the delay before the next attempt after attempt failed with err.
+// Retry-After requested by AH takes precedence over backoff
+func (r RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) && deliveryErr.RetryAfter > 0 {
+		if r.MaxDelay > 0 && deliveryErr.RetryAfter > r.MaxDelay {
+			return 0, false
+		}
+		return deliveryErr.RetryAfter, true
+	}
+
+	backoff := r.BaseDelay << uint(attempt-1)
+	if backoff <= 0 || (r.MaxDelay > 0 && backoff > r.MaxDelay) {
+		backoff = r.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	// full jitter spreads retries of concurrent requests
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), true
+}
+
+// isTransient reports whether err can disappear on retry
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, ErrUnreachable) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUnexpectedStatus)
+}
+
+// parseRetryAfter parses value of Retry-After header which is either seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// BreakerState is a state of CircuitBreaker
+type BreakerState int
+
+// States of CircuitBreaker
+const (
+	// BreakerClosed allows all requests
+	BreakerClosed BreakerState = iota
+	// BreakerOpen pauses all requests until cooldown is over
+	BreakerOpen
+	// BreakerHalfOpen allows a single probe request which decides whether to close or open the breaker again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker pauses requests to AH after a number of consecutive failures. It is safe for concurrent use
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates breaker which opens after threshold consecutive failures
+// and allows a probe request after cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a request can be made. Every allowed request must be followed by Success, Failure or Release
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update()
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// Success records successful request and closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records failed request. The breaker opens on threshold consecutive failures or failed probe
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// Release records request which neither succeeded nor failed, e.g. cancelled one.
+// It keeps the state and lets half-open breaker allow another probe
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update()
+	return b.state
+}
+
+// ResumeAt returns time when paused requests will be probed again. It is zero if the breaker is not open
+func (b *CircuitBreaker) ResumeAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update()
+	if b.state != BreakerOpen {
+		return time.Time{}
+	}
+	return b.openedAt.Add(b.cooldown)
+}
+
+// update moves open breaker to half-open state when cooldown is over
+func (b *CircuitBreaker) update() {
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.cooldown)) {
+		b.state = BreakerHalfOpen
+		b.probing = false
+	}
+}
@@ -0,0 +1,206 @@
+package ahhelperbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_RetriesTransientErrors(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	calls := 0
+
+	// Act
+	err := r.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &DeliveryError{Kind: ErrUnreachable}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicy_DoesNotRetryPermanentErrors(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	calls := 0
+
+	// Act
+	err := r.Do(context.Background(), func() error {
+		calls++
+		return &DeliveryError{Kind: ErrUnknownPostcode}
+	})
+
+	assert.True(t, errors.Is(err, ErrUnknownPostcode))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_StopsAfterMaxAttempts(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	calls := 0
+
+	// Act
+	err := r.Do(context.Background(), func() error {
+		calls++
+		return &DeliveryError{Kind: ErrUnexpectedStatus}
+	})
+
+	assert.True(t, errors.Is(err, ErrUnexpectedStatus))
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryPolicy_StopsOnCancelledContext(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	// Act
+	err := r.Do(ctx, func() error {
+		calls++
+		cancel()
+		return &DeliveryError{Kind: ErrUnreachable}
+	})
+
+	assert.True(t, errors.Is(err, ErrUnreachable))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_RetryAfterExceedsMaxDelay(t *testing.T) {
+	r := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+	calls := 0
+
+	// Act
+	err := r.Do(context.Background(), func() error {
+		calls++
+		return &DeliveryError{Kind: ErrRateLimited, RetryAfter: time.Minute}
+	})
+
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_DelayIsJitteredBackoff(t *testing.T) {
+	r := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	err := &DeliveryError{Kind: ErrUnreachable}
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		// Act
+		delay, ok := r.delay(attempt, err)
+
+		assert.True(t, ok)
+		assert.True(t, delay >= 0, "negative delay %s", delay)
+		assert.True(t, delay <= 300*time.Millisecond, "delay %s exceeds MaxDelay", delay)
+		if attempt == 1 {
+			assert.True(t, delay <= 100*time.Millisecond, "delay %s exceeds BaseDelay", delay)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 4, 6, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			// Act
+			d := parseRetryAfter(tc.value, now)
+
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func newTestCircuitBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2020, 4, 6, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(threshold, cooldown)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	b, now := newTestCircuitBreaker(2, time.Minute)
+
+	// Act
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+	b.Failure()
+
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+	assert.Equal(t, now.Add(time.Minute), b.ResumeAt())
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	b, _ := newTestCircuitBreaker(2, time.Minute)
+
+	// Act
+	b.Failure()
+	b.Success()
+	b.Failure()
+
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.True(t, b.Allow())
+	assert.True(t, b.ResumeAt().IsZero())
+}
+
+func TestCircuitBreaker_HalfOpenAllowsSingleProbe(t *testing.T) {
+	b, now := newTestCircuitBreaker(1, time.Minute)
+	b.Failure()
+
+	// Act
+	*now = now.Add(time.Minute)
+
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestCircuitBreaker_ProbeClosesOrReopens(t *testing.T) {
+	b, now := newTestCircuitBreaker(3, time.Minute)
+	for i := 0; i < 3; i++ {
+		b.Failure()
+	}
+	*now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+
+	// Act
+	b.Failure()
+
+	assert.Equal(t, BreakerOpen, b.State())
+
+	*now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+
+	// Act
+	b.Success()
+
+	assert.Equal(t, BreakerClosed, b.State())
+}
+
+func TestCircuitBreaker_ReleaseKeepsState(t *testing.T) {
+	b, now := newTestCircuitBreaker(1, time.Minute)
+	b.Failure()
+	*now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+
+	// Act
+	b.Release()
+
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.True(t, b.Allow())
+}
@@ -0,0 +1,146 @@
+package ahhelperbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/telegram"
+)
+
+// defaultLanguage is used for users with unsupported language
+const defaultLanguage = "en"
+
+// languages of commands descriptions and help
+var languages = []string{defaultLanguage, "nl"}
+
+// helpTitle is the first line of help by language
+var helpTitle = localized{
+	"en": "Help for the AH chatbot.",
+	"nl": "Hulp voor de AH chatbot.",
+}
+
+// localized is a text by language
+type localized map[string]string
+
+// in returns text in language. Language code may include region, e.g. nl-NL.
+// The default language is used if there is no text in language
+func (l localized) in(language string) string {
+	if text, ok := l[supportedLanguage(language)]; ok {
+		return text
+	}
+	return l[defaultLanguage]
+}
+
+// supportedLanguage returns language without region if it's supported, otherwise the default language
+func supportedLanguage(language string) string {
+	if i := strings.Index(language, "-"); i >= 0 {
+		language = language[:i]
+	}
+	language = strings.ToLower(language)
+	for _, l := range languages {
+		if l == language {
+			return l
+		}
+	}
+	return defaultLanguage
+}
+
+// commandHandler processes command message with arguments
+type commandHandler func(ctx context.Context, msg domain.Message, args string)
+
+// command is a bot command like /addme 1234AB
+type command struct {
+	// name without slash
+	name string
+	// args describes required arguments, e.g. 1234AB. Command without args ignores arguments
+	args        string
+	description localized
+	// hidden commands are not listed in help
+	hidden  bool
+	handler commandHandler
+}
+
+// usage returns command with its arguments, e.g. /addme 1234AB
+func (c command) usage() string {
+	if len(c.args) == 0 {
+		return "/" + c.name
+	}
+	return fmt.Sprintf("/%s %s", c.name, c.args)
+}
+
+// commandRouter dispatches commands to registered handlers
+type commandRouter struct {
+	commands []command
+	byName   map[string]command
+}
+
+func newCommandRouter() *commandRouter {
+	return &commandRouter{byName: map[string]command{}}
+}
+
+// register adds command. Commands are listed in help in order of registration
+func (r *commandRouter) register(c command) {
+	r.commands = append(r.commands, c)
+	r.byName[c.name] = c
+}
+
+// help returns description of registered commands in language
+func (r *commandRouter) help(language string) string {
+	var text strings.Builder
+	text.WriteString(helpTitle.in(language) + "\n")
+	for _, c := range r.commands {
+		if c.hidden {
+			continue
+		}
+		text.WriteString(fmt.Sprintf("%s - %s\n", c.usage(), c.description.in(language)))
+	}
+	return text.String()
+}
+
+// botCommands returns registered commands for telegram autocomplete in language
+func (r *commandRouter) botCommands(language string) []telegram.BotCommand {
+	commands := []telegram.BotCommand{}
+	for _, c := range r.commands {
+		if c.hidden {
+			continue
+		}
+		description := c.description.in(language)
+		if len(c.args) > 0 {
+			description = fmt.Sprintf("%s: /%s %s", description, c.name, c.args)
+		}
+		commands = append(commands, telegram.BotCommand{Command: c.name, Description: description})
+	}
+	return commands
+}
+
+// parsedCommand is a message like /command@botname args
+type parsedCommand struct {
+	name string
+	// botName is empty if command is not addressed to a specific bot
+	botName string
+	args    string
+}
+
+// parseCommand parses message text. It returns false if the text is not a command
+func parseCommand(text string) (parsedCommand, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return parsedCommand{}, false
+	}
+
+	name := text[1:]
+	args := ""
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	botName := ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, botName = name[:i], name[i+1:]
+	}
+	if len(name) == 0 {
+		return parsedCommand{}, false
+	}
+	return parsedCommand{name: strings.ToLower(name), botName: botName, args: args}, true
+}
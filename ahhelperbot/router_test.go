@@ -0,0 +1,132 @@
+package ahhelperbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/telegram"
+)
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected parsedCommand
+		ok       bool
+	}{
+		{"/check", parsedCommand{name: "check"}, true},
+		{"  /check  ", parsedCommand{name: "check"}, true},
+		{"/Check@AHHelperBot", parsedCommand{name: "check", botName: "AHHelperBot"}, true},
+		{"/addme 1234 AB", parsedCommand{name: "addme", args: "1234 AB"}, true},
+		{"/addme@AHHelperBot   1234AB ", parsedCommand{name: "addme", botName: "AHHelperBot", args: "1234AB"}, true},
+		{"/checkfoo", parsedCommand{name: "checkfoo"}, true},
+		{"check", parsedCommand{}, false},
+		{"/", parsedCommand{}, false},
+		{"/@AHHelperBot", parsedCommand{}, false},
+		{"", parsedCommand{}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			// Act
+			parsed, ok := parseCommand(tc.text)
+
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, parsed)
+		})
+	}
+}
+
+func TestCommandRouter_Help(t *testing.T) {
+	r := newCommandRouter()
+	noop := func(ctx context.Context, msg domain.Message, args string) {}
+	r.register(command{name: "addme", args: "1234AB", handler: noop,
+		description: localized{"en": "register a postcode", "nl": "registreer een postcode"}})
+	r.register(command{name: "start", hidden: true, handler: noop})
+	r.register(command{name: "list", handler: noop,
+		description: localized{"en": "show your postcodes", "nl": "toon je postcodes"}})
+
+	// Act
+	help := r.help("en")
+
+	assert.Equal(t, "Help for the AH chatbot.\n/addme 1234AB - register a postcode\n/list - show your postcodes\n", help)
+
+	// Act
+	help = r.help("nl-NL")
+
+	assert.Equal(t, "Hulp voor de AH chatbot.\n/addme 1234AB - registreer een postcode\n/list - toon je postcodes\n", help)
+
+	// Act
+	help = r.help("de")
+
+	assert.Contains(t, help, "Help for the AH chatbot.")
+}
+
+func TestBot_PublishCommands(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(newTestStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	err := bot.PublishCommands()
+
+	assert.NoError(t, err)
+	assert.Len(t, fakeMessenger.commands, 2)
+	assert.Equal(t, telegram.BotCommand{
+		Command:     "addme",
+		Description: "register a postcode, you can register several postcodes: /addme 1234AB",
+	}, fakeMessenger.commands[""][0])
+	assert.Equal(t, telegram.BotCommand{Command: "list", Description: "toon je postcodes"}, fakeMessenger.commands["nl"][1])
+	help := bot.router.help("nl")
+	for _, c := range fakeMessenger.commands["nl"] {
+		assert.Contains(t, help, "/"+c.Command)
+		assert.NotEqual(t, "start", c.Command)
+	}
+}
+
+func TestBotMessageProcessor_Commands(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"addressed to bot", "/list@AHHelperBot", "Your postcodes"},
+		{"addressed to bot case insensitive", "/list@ahhelperbot", "Your postcodes"},
+		{"addressed to other bot", "/list@OtherBot", ""},
+		{"unknown command", "/checkfoo", "Unknown command /checkfoo"},
+		{"missing arguments", "/addme", "Command /addme requires arguments: /addme 1234AB"},
+		{"help", "/help", "/removeme 1234AB - remove one of your postcodes"},
+		{"start", "/start", "Help for the AH chatbot"},
+		{"text", "hello", "Help for the AH chatbot"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeMessenger := newFakeMessenger()
+			s := newTestStorage(domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+			bot := NewBot(s, &fakeDeliveryProvider{})
+			bot.SetMessenger(fakeMessenger)
+			bot.SetBotName("AHHelperBot")
+
+			// Act
+			bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: tc.text})
+
+			if len(tc.expected) == 0 {
+				assert.Empty(t, fakeMessenger.sentMessages)
+				return
+			}
+			assert.Contains(t, fakeMessenger.sentMessages[1], tc.expected)
+		})
+	}
+}
+
+func TestBotMessageProcessor_HelpInLanguageOfUser(t *testing.T) {
+	fakeMessenger := newFakeMessenger()
+	bot := NewBot(newTestStorage(), &fakeDeliveryProvider{})
+	bot.SetMessenger(fakeMessenger)
+
+	// Act
+	bot.DefaultMessageProcessor(context.Background(), domain.Message{ChatID: 1, Text: "/help", LanguageCode: "nl"})
+
+	assert.Contains(t, fakeMessenger.sentMessages[1], "/list - toon je postcodes")
+}
@@ -0,0 +1,129 @@
+package ahhelperbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// scheduleDateLayout is the layout of dates of schedule used by AH
+const scheduleDateLayout = "2006-01-02"
+
+// weekdays are short names of weekdays by language, starting from Sunday
+var weekdays = map[string][7]string{
+	"en": {"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	"nl": {"zo", "ma", "di", "wo", "do", "vr", "za"},
+}
+
+// months are short names of months by language, starting from January
+var months = map[string][12]string{
+	"en": {"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+	"nl": {"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+}
+
+// formatDate returns date of schedule with weekday in language, e.g. ma 18 mei.
+// Date in unknown format is returned as is
+func formatDate(date string, language string) string {
+	t, err := time.Parse(scheduleDateLayout, date)
+	if err != nil {
+		return date
+	}
+	language = supportedLanguage(language)
+	return fmt.Sprintf("%s %d %s", weekdays[language][t.Weekday()], t.Day(), months[language][t.Month()-1])
+}
+
+// slotRange is a range of adjacent slots with the same Dl and price
+type slotRange struct {
+	Start, End time.Time
+	Dl         int
+	// Price in euro, zero if unknown
+	Price float64
+}
+
+// groupSlots sorts slots by time and merges adjacent slots with the same Dl and price into ranges.
+// Duplicated slots are ignored
+func groupSlots(slots []domain.Slot) []slotRange {
+	sorted := append([]domain.Slot{}, slots...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Start.Equal(sorted[j].Start) {
+			return sorted[i].Start.Before(sorted[j].Start)
+		}
+		return sorted[i].End.Before(sorted[j].End)
+	})
+
+	ranges := []slotRange{}
+	for i, slot := range sorted {
+		if i > 0 && slot.Start.Equal(sorted[i-1].Start) && slot.End.Equal(sorted[i-1].End) {
+			continue
+		}
+		last := len(ranges) - 1
+		if last >= 0 && ranges[last].End.Equal(slot.Start) && ranges[last].Dl == slot.Dl && ranges[last].Price == slot.Price {
+			ranges[last].End = slot.End
+			continue
+		}
+		ranges = append(ranges, slotRange{Start: slot.Start, End: slot.End, Dl: slot.Dl, Price: slot.Price})
+	}
+	return ranges
+}
+
+// formatPrice returns price in euro with decimal separator of language, e.g. €5,95
+func formatPrice(price float64, language string) string {
+	text := fmt.Sprintf("€%.2f", price)
+	if supportedLanguage(language) == "nl" {
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	return text
+}
+
+// format returns range like 08:00-10:00 €5.95 (dl 1) with price in language. Unknown price and Dl are omitted
+func (r slotRange) format(language string) string {
+	end := r.End.Format("15:04")
+	if end == "00:00" {
+		// the range ends at midnight of the next day
+		end = "24:00"
+	}
+	text := r.Start.Format("15:04") + "-" + end
+	if r.Price > 0 {
+		text += " " + formatPrice(r.Price, language)
+	}
+	if r.Dl > 0 {
+		text += fmt.Sprintf(" (dl %d)", r.Dl)
+	}
+	return text
+}
+
+func (r slotRange) String() string {
+	return r.format(defaultLanguage)
+}
+
+// RichText returns schedule sorted by date and time with weekdays in language.
+// Every date is on its own line, adjacent slots with the same price are grouped
+func (ds DeliverySchedule) RichText(language string) domain.RichText {
+	dates := []string{}
+	for date, slots := range ds {
+		if len(slots) > 0 {
+			dates = append(dates, date)
+		}
+	}
+	// dates of AH are sorted chronologically as strings
+	sort.Strings(dates)
+
+	text := domain.RichText{}
+	for _, date := range dates {
+		ranges := []string{}
+		for _, r := range groupSlots(ds[date]) {
+			ranges = append(ranges, r.format(language))
+		}
+		text = append(text,
+			domain.Bold(formatDate(date, language)),
+			domain.Plainf(": %s\n", strings.Join(ranges, ", ")))
+	}
+	return text
+}
+
+func (ds DeliverySchedule) String() string {
+	return ds.RichText(defaultLanguage).String()
+}
@@ -0,0 +1,122 @@
+package ahhelperbot
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// update rewrites snapshots in testdata/schedule: go test ./ahhelperbot -run Snapshot -update
+var update = flag.Bool("update", false, "update snapshots in testdata")
+
+// testSchedule has unsorted dates and slots, adjacent and duplicated slots
+var testSchedule = DeliverySchedule{
+	"2020-05-19": {
+		withPrice(newTestSlot("2020-05-19", "18:00", "20:00", 2), 6.95),
+		withPrice(newTestSlot("2020-05-19", "08:00", "10:00", 1), 4.95),
+		withPrice(newTestSlot("2020-05-19", "20:00", "22:00", 2), 4.95),
+	},
+	"2020-05-18": {
+		newTestSlot("2020-05-18", "10:00", "12:00", 1),
+		newTestSlot("2020-05-18", "08:00", "10:00", 1),
+		newTestSlot("2020-05-18", "08:00", "10:00", 1),
+		newTestSlot("2020-05-18", "12:00", "14:00", 3),
+		newTestSlot("2020-05-18", "16:00", "18:00", 1),
+	},
+	"2020-06-01": {
+		newTestSlot("2020-06-01", "07:00", "09:00", 0),
+		newTestSlot("2020-06-01", "22:00", "24:00", 0),
+	},
+	"2020-05-20": {},
+}
+
+// withPrice returns slot with price in euro
+func withPrice(slot domain.Slot, price float64) domain.Slot {
+	slot.Price = price
+	slot.Raw.Value = price
+	return slot
+}
+
+func TestDeliverySchedule_RichTextSnapshot(t *testing.T) {
+	for _, language := range []string{"en", "nl", "nl-NL"} {
+		t.Run(language, func(t *testing.T) {
+			// Act
+			text := testSchedule.RichText(language).String()
+
+			snapshot := filepath.Join("testdata", "schedule", language+".txt")
+			if *update {
+				assert.NoError(t, ioutil.WriteFile(snapshot, []byte(text), 0644))
+			}
+			expected, err := ioutil.ReadFile(snapshot)
+			assert.NoError(t, err)
+			assert.Equal(t, string(expected), text)
+		})
+	}
+}
+
+func TestDeliverySchedule_RichTextIsDeterministic(t *testing.T) {
+	expected := testSchedule.RichText("nl")
+
+	for i := 0; i < 10; i++ {
+		// Act
+		text := testSchedule.RichText("nl")
+
+		assert.Equal(t, expected, text)
+	}
+	assert.Equal(t, domain.Bold("ma 18 mei"), expected[0])
+}
+
+func TestFormatDate(t *testing.T) {
+	testCases := []struct {
+		date     string
+		language string
+		expected string
+	}{
+		{"2020-05-18", "nl", "ma 18 mei"},
+		{"2020-05-18", "en", "Mon 18 May"},
+		{"2020-03-01", "nl-BE", "zo 1 mrt"},
+		{"2020-10-03", "de", "Sat 3 Oct"},
+		{"01-01-1970", "nl", "01-01-1970"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.date+" "+tc.language, func(t *testing.T) {
+			// Act
+			date := formatDate(tc.date, tc.language)
+
+			assert.Equal(t, tc.expected, date)
+		})
+	}
+}
+
+func TestGroupSlots(t *testing.T) {
+	// Act
+	ranges := groupSlots([]domain.Slot{
+		newTestSlot("2020-05-18", "10:00", "12:00", 1),
+		newTestSlot("2020-05-18", "08:00", "10:00", 1),
+		newTestSlot("2020-05-18", "12:00", "14:00", 1),
+		newTestSlot("2020-05-18", "14:00", "16:00", 2),
+		newTestSlot("2020-05-18", "18:00", "20:00", 2),
+	})
+
+	assert.Equal(t, []string{"08:00-14:00 (dl 1)", "14:00-16:00 (dl 2)", "18:00-20:00 (dl 2)"},
+		[]string{ranges[0].String(), ranges[1].String(), ranges[2].String()})
+	assert.Len(t, ranges, 3)
+}
+
+func TestGroupSlotsByPrice(t *testing.T) {
+	// Act
+	ranges := groupSlots([]domain.Slot{
+		withPrice(newTestSlot("2020-05-18", "08:00", "10:00", 1), 4.95),
+		withPrice(newTestSlot("2020-05-18", "10:00", "12:00", 1), 4.95),
+		withPrice(newTestSlot("2020-05-18", "12:00", "14:00", 1), 6.95),
+	})
+
+	assert.Len(t, ranges, 2)
+	assert.Equal(t, "08:00-12:00 €4.95 (dl 1)", ranges[0].String())
+	assert.Equal(t, "12:00-14:00 €6,95 (dl 1)", ranges[1].format("nl"))
+}
@@ -0,0 +1,24 @@
+package domain
+
+import "fmt"
+
+// CheckSummary describes a run of delivery checks
+type CheckSummary struct {
+	// Postcodes is a number of postcodes to check
+	Postcodes int
+	Succeeded int
+	Failed    int
+	// Paused is a number of failed postcodes which are not requested because checks are paused
+	Paused int
+
+	// Notified is a number of chats notified about changes
+	Notified            int
+	NotificationsFailed int
+	// Deactivated is a number of subscriptions removed because their chats are not available anymore
+	Deactivated int
+}
+
+func (s CheckSummary) String() string {
+	return fmt.Sprintf("%d postcodes checked: %d succeeded, %d failed (%d paused); %d chats notified, %d notifications failed, %d subscriptions deactivated",
+		s.Postcodes, s.Succeeded, s.Failed, s.Paused, s.Notified, s.NotificationsFailed, s.Deactivated)
+}
@@ -0,0 +1,245 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a period of day. Start and End are offsets from midnight, End can be 24:00
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Filter selects slots of subscription. Zero value accepts all slots
+type Filter struct {
+	// Weekdays of slots, all days are accepted if empty
+	Weekdays []time.Weekday
+	// Windows of day, slot must be within one of them. All times are accepted if empty
+	Windows []TimeWindow
+	// MinLead and MaxLead limit time from now to the start of slot. Zero is no limit
+	MinLead time.Duration
+	MaxLead time.Duration
+	// MaxPrice of slot in euro. Zero is no limit, slots with unknown price are accepted
+	MaxPrice float64
+}
+
+// Reasons why a filter is rejected by parsers
+var (
+	ErrFilterWeekdays = errors.New("weekdays should be like mo-fr or mo,we,sa")
+	ErrFilterWindow   = errors.New("time should be like 18:00-22:00")
+	ErrFilterLead     = errors.New("lead time should be like 2h-3d, 2h- or -3d")
+	ErrFilterPrice    = errors.New("price should be like 5.95")
+)
+
+// weekdayNames are English and Dutch abbreviations of weekdays
+var weekdayNames = map[string]time.Weekday{
+	"su": time.Sunday, "mo": time.Monday, "tu": time.Tuesday, "we": time.Wednesday,
+	"th": time.Thursday, "fr": time.Friday, "sa": time.Saturday,
+	"zo": time.Sunday, "ma": time.Monday, "di": time.Tuesday, "wo": time.Wednesday,
+	"do": time.Thursday, "vr": time.Friday, "za": time.Saturday,
+}
+
+// weekdayAbbreviations are English abbreviations of weekdays starting from Sunday
+var weekdayAbbreviations = [7]string{"su", "mo", "tu", "we", "th", "fr", "sa"}
+
+// ParseWeekdays parses comma separated weekdays and ranges of weekdays in English or Dutch,
+// e.g. "mo-fr", "za,zo" or "mo,we-fr". Ranges can wrap over the week, e.g. "fr-mo"
+func ParseWeekdays(s string) ([]time.Weekday, error) {
+	seen := map[time.Weekday]bool{}
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.Split(strings.TrimSpace(item), "-")
+		if len(bounds) > 2 {
+			return nil, ErrFilterWeekdays
+		}
+		first, ok := weekdayNames[bounds[0]]
+		if !ok {
+			return nil, ErrFilterWeekdays
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayNames[bounds[1]]; !ok {
+				return nil, ErrFilterWeekdays
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			seen[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	weekdays := []time.Weekday{}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if seen[day] {
+			weekdays = append(weekdays, day)
+		}
+	}
+	return weekdays, nil
+}
+
+// ParseTimeWindow parses period of day like "18:00-22:00". It can't wrap over midnight, use 24:00 instead
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	bounds := strings.Split(s, "-")
+	if len(bounds) != 2 {
+		return TimeWindow{}, ErrFilterWindow
+	}
+	start, err := ParseTimeOfDay(bounds[0])
+	if err != nil {
+		return TimeWindow{}, ErrFilterWindow
+	}
+	end, err := ParseTimeOfDay(bounds[1])
+	if err != nil {
+		return TimeWindow{}, ErrFilterWindow
+	}
+	if end <= start {
+		return TimeWindow{}, ErrFilterWindow
+	}
+	return TimeWindow{Start: start, End: end}, nil
+}
+
+func (w TimeWindow) String() string {
+	return FormatTimeOfDay(w.Start) + "-" + FormatTimeOfDay(w.End)
+}
+
+// ParseLead parses range of lead time like "2h-3d". Either bound can be omitted, e.g. "-3d" or "2h-".
+// Durations are in days (d) or in format of time.ParseDuration
+func ParseLead(s string) (min time.Duration, max time.Duration, err error) {
+	bounds := strings.Split(s, "-")
+	if len(bounds) != 2 {
+		return 0, 0, ErrFilterLead
+	}
+	if min, err = parseLeadDuration(bounds[0]); err != nil {
+		return 0, 0, err
+	}
+	if max, err = parseLeadDuration(bounds[1]); err != nil {
+		return 0, 0, err
+	}
+	if max > 0 && max < min {
+		return 0, 0, ErrFilterLead
+	}
+	return min, max, nil
+}
+
+func parseLeadDuration(s string) (time.Duration, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, ErrFilterLead
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, ErrFilterLead
+	}
+	return d, nil
+}
+
+// formatLeadDuration formats duration in days or hours if it's whole, e.g. 3d or 2h
+func formatLeadDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return ""
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return d.String()
+}
+
+// ParsePrice parses price in euro like "5.95" or "5,95"
+func ParsePrice(s string) (float64, error) {
+	price, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil || price < 0 {
+		return 0, ErrFilterPrice
+	}
+	return price, nil
+}
+
+// IsEmpty reports whether filter accepts all slots
+func (f Filter) IsEmpty() bool {
+	return len(f.Weekdays) == 0 && len(f.Windows) == 0 && f.MinLead == 0 && f.MaxLead == 0 && f.MaxPrice == 0
+}
+
+// Accepts reports whether slot passes the filter. Lead time is counted from now
+func (f Filter) Accepts(slot Slot, now time.Time) bool {
+	if len(f.Weekdays) > 0 && !f.acceptsWeekday(slot.Start.Weekday()) {
+		return false
+	}
+	if len(f.Windows) > 0 && !f.acceptsTime(slot) {
+		return false
+	}
+	lead := slot.Start.Sub(now)
+	if f.MinLead > 0 && lead < f.MinLead {
+		return false
+	}
+	if f.MaxLead > 0 && lead > f.MaxLead {
+		return false
+	}
+	if f.MaxPrice > 0 && slot.Price > f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+func (f Filter) acceptsWeekday(weekday time.Weekday) bool {
+	for _, day := range f.Weekdays {
+		if day == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsTime reports whether slot is entirely within one of windows
+func (f Filter) acceptsTime(slot Slot) bool {
+	start := TimeOfDay(slot.Start)
+	end := TimeOfDay(slot.End)
+	if slot.End.YearDay() != slot.Start.YearDay() {
+		end += 24 * time.Hour
+	}
+	for _, w := range f.Windows {
+		if start >= w.Start && end <= w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes filter like "weekdays mo,tu; time 18:00-22:00; lead -3d; price 5.95"
+func (f Filter) String() string {
+	if f.IsEmpty() {
+		return "no filters"
+	}
+	descriptions := []string{}
+	if len(f.Weekdays) > 0 {
+		days := []string{}
+		for _, day := range f.Weekdays {
+			days = append(days, weekdayAbbreviations[day])
+		}
+		descriptions = append(descriptions, "weekdays "+strings.Join(days, ","))
+	}
+	if len(f.Windows) > 0 {
+		windows := []string{}
+		for _, w := range f.Windows {
+			windows = append(windows, w.String())
+		}
+		descriptions = append(descriptions, "time "+strings.Join(windows, " "))
+	}
+	if f.MinLead > 0 || f.MaxLead > 0 {
+		descriptions = append(descriptions, "lead "+formatLeadDuration(f.MinLead)+"-"+formatLeadDuration(f.MaxLead))
+	}
+	if f.MaxPrice > 0 {
+		descriptions = append(descriptions, "price "+strconv.FormatFloat(f.MaxPrice, 'f', 2, 64))
+	}
+	return strings.Join(descriptions, "; ")
+}
@@ -0,0 +1,149 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []time.Weekday
+		err      error
+	}{
+		{"mo-fr", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil},
+		{"za,zo", []time.Weekday{time.Sunday, time.Saturday}, nil},
+		{"Mo,we-th", []time.Weekday{time.Monday, time.Wednesday, time.Thursday}, nil},
+		{"fr-mo", []time.Weekday{time.Sunday, time.Monday, time.Friday, time.Saturday}, nil},
+		{"ma-di,di", []time.Weekday{time.Monday, time.Tuesday}, nil},
+		{"", nil, ErrFilterWeekdays},
+		{"monday", nil, ErrFilterWeekdays},
+		{"mo-tu-we", nil, ErrFilterWeekdays},
+		{"mo-xx", nil, ErrFilterWeekdays},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			// Act
+			weekdays, err := ParseWeekdays(tc.input)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, weekdays)
+		})
+	}
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected TimeWindow
+		err      error
+	}{
+		{"18:00-22:00", TimeWindow{18 * time.Hour, 22 * time.Hour}, nil},
+		{"20:30-24:00", TimeWindow{20*time.Hour + 30*time.Minute, 24 * time.Hour}, nil},
+		{"22:00-18:00", TimeWindow{}, ErrFilterWindow},
+		{"18:00", TimeWindow{}, ErrFilterWindow},
+		{"18-22", TimeWindow{}, ErrFilterWindow},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			// Act
+			w, err := ParseTimeWindow(tc.input)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, w)
+		})
+	}
+}
+
+func TestParseLead(t *testing.T) {
+	testCases := []struct {
+		input string
+		min   time.Duration
+		max   time.Duration
+		err   error
+	}{
+		{"2h-3d", 2 * time.Hour, 72 * time.Hour, nil},
+		{"-3d", 0, 72 * time.Hour, nil},
+		{"90m-", 90 * time.Minute, 0, nil},
+		{"3d-2h", 0, 0, ErrFilterLead},
+		{"3d", 0, 0, ErrFilterLead},
+		{"xd-", 0, 0, ErrFilterLead},
+		{"-2x", 0, 0, ErrFilterLead},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			// Act
+			min, max, err := ParseLead(tc.input)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.min, min)
+			assert.Equal(t, tc.max, max)
+		})
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	price, err := ParsePrice("5,95")
+	assert.NoError(t, err)
+	assert.Equal(t, 5.95, price)
+
+	_, err = ParsePrice("-1")
+	assert.Equal(t, ErrFilterPrice, err)
+}
+
+func TestFilter_Accepts(t *testing.T) {
+	amsterdam, err := time.LoadLocation(SlotTimezone)
+	assert.NoError(t, err)
+	// Monday
+	now := time.Date(2020, 5, 18, 12, 0, 0, 0, amsterdam)
+	slot := func(date string, from string, to string, price float64) Slot {
+		s, err := NewSlot(RawSlot{Date: date, From: from, To: to, State: "selectable", Value: price}, amsterdam)
+		assert.NoError(t, err)
+		return s
+	}
+	filter := Filter{
+		Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Windows:  []TimeWindow{{Start: 18 * time.Hour, End: 24 * time.Hour}},
+		MinLead:  2 * time.Hour,
+		MaxLead:  72 * time.Hour,
+		MaxPrice: 5.95,
+	}
+	testCases := []struct {
+		name     string
+		slot     Slot
+		expected bool
+	}{
+		{"accepted", slot("2020-05-19", "18:00", "20:00", 4.95), true},
+		{"till midnight", slot("2020-05-19", "22:00", "24:00", 0), true},
+		{"weekend", slot("2020-05-23", "18:00", "20:00", 4.95), false},
+		{"out of window", slot("2020-05-19", "17:00", "19:00", 4.95), false},
+		{"too soon", slot("2020-05-18", "13:00", "15:00", 4.95), false},
+		{"too late", slot("2020-05-22", "18:00", "20:00", 4.95), false},
+		{"too expensive", slot("2020-05-19", "18:00", "20:00", 6.95), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			accepted := filter.Accepts(tc.slot, now)
+
+			assert.Equal(t, tc.expected, accepted)
+		})
+	}
+
+	assert.True(t, Filter{}.Accepts(slot("2020-05-23", "08:00", "10:00", 9.95), now))
+}
+
+func TestFilter_String(t *testing.T) {
+	filter := Filter{
+		Weekdays: []time.Weekday{time.Monday, time.Friday},
+		Windows:  []TimeWindow{{Start: 8 * time.Hour, End: 10 * time.Hour}, {Start: 18 * time.Hour, End: 24 * time.Hour}},
+		MaxLead:  72 * time.Hour,
+		MaxPrice: 5.95,
+	}
+
+	assert.Equal(t, "weekdays mo,fr; time 08:00-10:00 18:00-24:00; lead -3d; price 5.95", filter.String())
+	assert.Equal(t, "no filters", Filter{}.String())
+	assert.True(t, Filter{}.IsEmpty())
+}
@@ -2,6 +2,14 @@ package domain
 
 // Message - internal description of telegram message
 type Message struct {
+	// Text of received message or plain text of outgoing message
 	Text   string
 	ChatID ChatID
+	// Rich is formatted text of outgoing message. Text is ignored if Rich is set
+	Rich RichText
+	// LanguageCode of the sender, e.g. nl. Empty for outgoing messages
+	LanguageCode string
+	// Broadcast is set for outgoing messages to many chats, e.g. notifications.
+	// They are sent after replies to users
+	Broadcast bool
 }
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// Postcode is a Dutch postcode in canonical form: four digits followed by two uppercase letters without space, e.g. 1234AB
+type Postcode string
+
+// Reasons why a postcode is rejected by ParsePostcode
+var (
+	ErrPostcodeEmpty           = errors.New("postcode is empty")
+	ErrPostcodeFormat          = errors.New("postcode should be four digits followed by two letters, e.g. 1234AB")
+	ErrPostcodeLeadingZero     = errors.New("postcode cannot start with 0")
+	ErrPostcodeReservedLetters = errors.New("postcode cannot end with SA, SD or SS")
+)
+
+// ParsePostcode validates Dutch postcode and returns it in canonical form.
+// Letters can be lowercase and separated from digits with spaces, e.g. "1234 ab"
+func ParsePostcode(s string) (Postcode, error) {
+	p := strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	if len(p) == 0 {
+		return "", ErrPostcodeEmpty
+	}
+	if len(p) != 6 {
+		return "", ErrPostcodeFormat
+	}
+	for i := 0; i < 4; i++ {
+		if p[i] < '0' || p[i] > '9' {
+			return "", ErrPostcodeFormat
+		}
+	}
+	for i := 4; i < 6; i++ {
+		if p[i] < 'A' || p[i] > 'Z' {
+			return "", ErrPostcodeFormat
+		}
+	}
+	if p[0] == '0' {
+		return "", ErrPostcodeLeadingZero
+	}
+	switch p[4:] {
+	case "SA", "SD", "SS":
+		return "", ErrPostcodeReservedLetters
+	}
+	return Postcode(p), nil
+}
+
+func (p Postcode) String() string {
+	return string(p)
+}
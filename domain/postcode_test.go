@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePostcode(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Postcode
+		err      error
+	}{
+		{"1234AB", "1234AB", nil},
+		{"1234ab", "1234AB", nil},
+		{"1234 ab", "1234AB", nil},
+		{" 1234  Ab ", "1234AB", nil},
+		{"", "", ErrPostcodeEmpty},
+		{"   ", "", ErrPostcodeEmpty},
+		{"123456", "", ErrPostcodeFormat},
+		{"1234_A", "", ErrPostcodeFormat},
+		{"1234A", "", ErrPostcodeFormat},
+		{"1234ABC", "", ErrPostcodeFormat},
+		{"1234ÄB", "", ErrPostcodeFormat},
+		{"0234AB", "", ErrPostcodeLeadingZero},
+		{"1234SA", "", ErrPostcodeReservedLetters},
+		{"1234sd", "", ErrPostcodeReservedLetters},
+		{"1234SS", "", ErrPostcodeReservedLetters},
+		{"1234SB", "1234SB", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			// Act
+			p, err := ParsePostcode(tc.input)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, p)
+		})
+	}
+}
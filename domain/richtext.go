@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Style of a part of rich text
+type Style int
+
+// Styles of parts
+const (
+	StylePlain Style = iota
+	StyleBold
+	StyleCode
+	StyleLink
+	StyleList
+)
+
+// Part of rich text. Text is unescaped, it is escaped on rendering for the messenger
+type Part struct {
+	Style Style
+	Text  string
+	// URL of link
+	URL string
+	// Items of list
+	Items []RichText
+}
+
+// RichText is a formatted text built from typed parts
+type RichText []Part
+
+// Plain returns part without formatting
+func Plain(text string) Part {
+	return Part{Style: StylePlain, Text: text}
+}
+
+// Plainf returns part without formatting according to a format specifier
+func Plainf(format string, a ...interface{}) Part {
+	return Plain(fmt.Sprintf(format, a...))
+}
+
+// Bold returns bold part
+func Bold(text string) Part {
+	return Part{Style: StyleBold, Text: text}
+}
+
+// Code returns monospace part
+func Code(text string) Part {
+	return Part{Style: StyleCode, Text: text}
+}
+
+// Link returns link with text to url
+func Link(text string, url string) Part {
+	return Part{Style: StyleLink, Text: text, URL: url}
+}
+
+// List returns bulleted list, every item is on its own line
+func List(items ...RichText) Part {
+	return Part{Style: StyleList, Items: items}
+}
+
+// ListBullet starts every item of list
+const ListBullet = "• "
+
+// String returns text without formatting. It is used as the fallback if formatting is rejected
+func (t RichText) String() string {
+	var text strings.Builder
+	for _, part := range t {
+		switch part.Style {
+		case StyleLink:
+			text.WriteString(part.Text)
+			if part.Text != part.URL {
+				text.WriteString(" (" + part.URL + ")")
+			}
+		case StyleList:
+			for _, item := range part.Items {
+				text.WriteString(ListBullet + item.String() + "\n")
+			}
+		default:
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
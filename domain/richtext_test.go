@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRichText_String(t *testing.T) {
+	text := RichText{
+		Bold("1234AB"),
+		Plain("\n"),
+		Code("08:00-10:00"),
+		Plainf(" %d slots\n", 2),
+		List(RichText{Plain("first")}, RichText{Bold("second")}),
+		Link("AH", "https://www.ah.nl"),
+		Plain(" "),
+		Link("https://www.ah.nl", "https://www.ah.nl"),
+	}
+
+	// Act
+	s := text.String()
+
+	assert.Equal(t, "1234AB\n08:00-10:00 2 slots\n• first\n• second\nAH (https://www.ah.nl) https://www.ah.nl", s)
+}
@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SlotTimezone is the timezone of dates and times of AH delivery slots
+const SlotTimezone = "Europe/Amsterdam"
+
+// SlotState is availability of delivery slot
+type SlotState int
+
+// States of slots
+const (
+	SlotUnknown SlotState = iota
+	SlotAvailable
+	SlotFull
+)
+
+// ParseSlotState returns state of slot by state of AH, e.g. selectable or full
+func ParseSlotState(state string) SlotState {
+	switch state {
+	case "selectable":
+		return SlotAvailable
+	case "full":
+		return SlotFull
+	}
+	return SlotUnknown
+}
+
+func (s SlotState) String() string {
+	switch s {
+	case SlotAvailable:
+		return "available"
+	case SlotFull:
+		return "full"
+	}
+	return "unknown"
+}
+
+// RawSlot keeps fields of slot as they are received from AH
+type RawSlot struct {
+	// Date like 2020-05-18
+	Date string
+	// From and To are times like 08:00
+	From  string
+	To    string
+	State string
+	Dl    int
+	// Value is the price of slot in euro
+	Value float64
+}
+
+// Slot is a delivery slot of AH
+type Slot struct {
+	Start time.Time
+	End   time.Time
+	State SlotState
+	// Dl is the delivery price level of AH
+	Dl int
+	// Price of delivery in euro, zero if it's unknown
+	Price float64
+	// Raw fields of AH for debugging
+	Raw RawSlot
+}
+
+// ErrSlotMalformed is returned by NewSlot for slots with invalid date or times
+var ErrSlotMalformed = errors.New("malformed slot")
+
+const (
+	slotDateLayout = "2006-01-02"
+	slotTimeLayout = "15:04"
+)
+
+// NewSlot parses raw slot of AH with date and times in location.
+// Slot ending at 00:00 or 24:00 ends at midnight of the next day
+func NewSlot(raw RawSlot, location *time.Location) (Slot, error) {
+	date, err := time.ParseInLocation(slotDateLayout, raw.Date, location)
+	if err != nil {
+		return Slot{}, fmt.Errorf("%w: date '%s'", ErrSlotMalformed, raw.Date)
+	}
+	start, err := slotTime(date, raw.From)
+	if err != nil {
+		return Slot{}, err
+	}
+	end, err := slotTime(date, raw.To)
+	if err != nil {
+		return Slot{}, err
+	}
+	if !end.After(start) && end.Equal(date) {
+		end = date.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return Slot{}, fmt.Errorf("%w: %s-%s ends before it starts", ErrSlotMalformed, raw.From, raw.To)
+	}
+
+	return Slot{
+		Start: start,
+		End:   end,
+		State: ParseSlotState(raw.State),
+		Dl:    raw.Dl,
+		Price: raw.Value,
+		Raw:   raw,
+	}, nil
+}
+
+// slotTime returns time of day like 08:00 at date
+func slotTime(date time.Time, s string) (time.Time, error) {
+	if s == "24:00" {
+		return date.AddDate(0, 0, 1), nil
+	}
+	t, err := time.Parse(slotTimeLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: time '%s'", ErrSlotMalformed, s)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, date.Location()), nil
+}
+
+func (s Slot) String() string {
+	return fmt.Sprintf("%s %s-%s (%s)", s.Start.Format(slotDateLayout), s.Start.Format(slotTimeLayout), s.End.Format(slotTimeLayout), s.State)
+}
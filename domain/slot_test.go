@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSlot(t *testing.T) {
+	amsterdam, err := time.LoadLocation(SlotTimezone)
+	assert.NoError(t, err)
+	raw := RawSlot{Date: "2020-05-18", From: "08:00", To: "10:00", State: "selectable", Dl: 1}
+
+	// Act
+	slot, err := NewSlot(raw, amsterdam)
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 5, 18, 8, 0, 0, 0, amsterdam), slot.Start)
+	assert.Equal(t, time.Date(2020, 5, 18, 10, 0, 0, 0, amsterdam), slot.End)
+	assert.Equal(t, "2020-05-18T06:00:00Z", slot.Start.UTC().Format(time.RFC3339))
+	assert.Equal(t, SlotAvailable, slot.State)
+	assert.Equal(t, 1, slot.Dl)
+	assert.Equal(t, raw, slot.Raw)
+	assert.Equal(t, "2020-05-18 08:00-10:00 (available)", slot.String())
+}
+
+func TestNewSlotEndsAtMidnight(t *testing.T) {
+	for _, to := range []string{"00:00", "24:00"} {
+		t.Run(to, func(t *testing.T) {
+			// Act
+			slot, err := NewSlot(RawSlot{Date: "2020-05-18", From: "22:00", To: to}, time.UTC)
+
+			assert.NoError(t, err)
+			assert.Equal(t, time.Date(2020, 5, 19, 0, 0, 0, 0, time.UTC), slot.End)
+			assert.Equal(t, SlotUnknown, slot.State)
+		})
+	}
+}
+
+func TestNewSlotMalformed(t *testing.T) {
+	testCases := []RawSlot{
+		{Date: "18-05-2020", From: "08:00", To: "10:00"},
+		{Date: "", From: "08:00", To: "10:00"},
+		{Date: "2020-05-18", From: "8", To: "10:00"},
+		{Date: "2020-05-18", From: "08:00", To: "25:00"},
+		{Date: "2020-05-18", From: "10:00", To: "08:00"},
+		{Date: "2020-05-18", From: "10:00", To: "10:00"},
+	}
+	for _, raw := range testCases {
+		t.Run(raw.Date+" "+raw.From+"-"+raw.To, func(t *testing.T) {
+			// Act
+			_, err := NewSlot(raw, time.UTC)
+
+			assert.True(t, errors.Is(err, ErrSlotMalformed), "unexpected error %v", err)
+		})
+	}
+}
+
+func TestParseSlotState(t *testing.T) {
+	assert.Equal(t, SlotAvailable, ParseSlotState("selectable"))
+	assert.Equal(t, SlotFull, ParseSlotState("full"))
+	assert.Equal(t, SlotUnknown, ParseSlotState(""))
+	assert.Equal(t, "full", SlotFull.String())
+}
@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// UTF16Len returns length of s in UTF-16 code units, telegram limits length of messages in these units
+func UTF16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
+
+// Split splits text into parts of at most limit UTF-16 code units of plain text.
+// Text is split between lines, so every date of schedule stays in one part,
+// and a heading line is kept with the following line. A line longer than limit is split between characters.
+// Every part has its own formatted parts, so formatting stays balanced
+func (t RichText) Split(limit int) []RichText {
+	if UTF16Len(t.String()) <= limit {
+		return []RichText{t}
+	}
+
+	res := []RichText{}
+	chunk, chunkLen := RichText{}, 0
+	add := func(text RichText) {
+		textLen := UTF16Len(text.String())
+		if chunkLen+textLen > limit && chunkLen > 0 {
+			res = append(res, chunk)
+			chunk, chunkLen = RichText{}, 0
+		}
+		if textLen <= limit {
+			chunk = append(chunk, text...)
+			chunkLen += textLen
+			return
+		}
+		pieces := text.splitRunes(limit)
+		res = append(res, pieces[:len(pieces)-1]...)
+		chunk = pieces[len(pieces)-1]
+		chunkLen = UTF16Len(chunk.String())
+	}
+
+	lines := t.lines()
+	for i := 0; i < len(lines); i++ {
+		if lines[i].isHeading() && i+1 < len(lines) {
+			block := append(append(RichText{}, lines[i]...), lines[i+1]...)
+			if UTF16Len(block.String()) <= limit {
+				add(block)
+				i++
+				continue
+			}
+		}
+		add(lines[i])
+	}
+	if len(chunk) > 0 {
+		res = append(res, chunk)
+	}
+	return res
+}
+
+// lines returns lines of text, every line but the last ends with a new line.
+// Lists are replaced by their lines
+func (t RichText) lines() []RichText {
+	lines := []RichText{}
+	line := RichText{}
+	for _, part := range t.flatten() {
+		texts := strings.Split(part.Text, "\n")
+		for i, text := range texts {
+			if len(text) > 0 {
+				p := part
+				p.Text = text
+				line = append(line, p)
+			}
+			if i < len(texts)-1 {
+				lines = append(lines, append(line, Plain("\n")))
+				line = RichText{}
+			}
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// flatten replaces lists by their items, each starting with a bullet and ending with a new line
+func (t RichText) flatten() RichText {
+	res := RichText{}
+	for _, part := range t {
+		if part.Style != StyleList {
+			res = append(res, part)
+			continue
+		}
+		for _, item := range part.Items {
+			res = append(res, Plain(ListBullet))
+			res = append(res, item.flatten()...)
+			res = append(res, Plain("\n"))
+		}
+	}
+	return res
+}
+
+// isHeading reports whether line is entirely bold
+func (t RichText) isHeading() bool {
+	bold := false
+	for _, part := range t {
+		if strings.TrimSpace(part.Text) == "" {
+			continue
+		}
+		if part.Style != StyleBold {
+			return false
+		}
+		bold = true
+	}
+	return bold
+}
+
+// splitRunes splits text into pieces of at most limit UTF-16 code units between characters
+func (t RichText) splitRunes(limit int) []RichText {
+	pieces := []RichText{}
+	piece, pieceLen := RichText{}, 0
+	for _, part := range t {
+		text := part.Text
+		for len(text) > 0 {
+			n := 0
+			for n < len(text) {
+				r, size := utf8.DecodeRuneInString(text[n:])
+				runeLen := UTF16Len(string(r))
+				if pieceLen+runeLen > limit && pieceLen > 0 {
+					break
+				}
+				pieceLen += runeLen
+				n += size
+			}
+			if n > 0 {
+				p := part
+				p.Text = text[:n]
+				piece = append(piece, p)
+				text = text[n:]
+			}
+			if len(text) > 0 {
+				pieces = append(pieces, piece)
+				piece, pieceLen = RichText{}, 0
+			}
+		}
+	}
+	if len(piece) > 0 {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTF16Len(t *testing.T) {
+	assert.Equal(t, 0, UTF16Len(""))
+	assert.Equal(t, 5, UTF16Len("18mei"))
+	assert.Equal(t, 3, UTF16Len("€ä "))
+	assert.Equal(t, 2, UTF16Len("🚚"))
+}
+
+func TestRichText_SplitShort(t *testing.T) {
+	text := RichText{Bold("1234AB"), Plain("\nma 18 mei: 08:00-10:00\n")}
+
+	// Act
+	parts := text.Split(100)
+
+	assert.Equal(t, []RichText{text}, parts)
+}
+
+func TestRichText_SplitLines(t *testing.T) {
+	text := RichText{
+		Bold("1234AA"), Plain("\n"),
+		Bold("ma"), Plain(": 08:00-10:00\n"),
+		Bold("di"), Plain(": 10:00-12:00\n"),
+		Plain("\n"),
+		Bold("1234AB"), Plain("\n"),
+		Bold("wo"), Plain(": 08:00-10:00\n"),
+	}
+
+	// Act
+	parts := text.Split(40)
+
+	assert.Equal(t, []RichText{
+		{
+			Bold("1234AA"), Plain("\n"),
+			Bold("ma"), Plain(": 08:00-10:00"), Plain("\n"),
+			Bold("di"), Plain(": 10:00-12:00"), Plain("\n"),
+			Plain("\n"),
+		},
+		{
+			Bold("1234AB"), Plain("\n"),
+			Bold("wo"), Plain(": 08:00-10:00"), Plain("\n"),
+		},
+	}, parts)
+	for _, part := range parts {
+		assert.LessOrEqual(t, UTF16Len(part.String()), 40)
+	}
+	assert.Equal(t, text.String(), parts[0].String()+parts[1].String())
+}
+
+func TestRichText_SplitKeepsHeadingWithNextLine(t *testing.T) {
+	text := RichText{
+		Plain("ma: 08:00-10:00\n"),
+		Bold("1234AB"), Plain("\n"),
+		Plain("di: 08:00-10:00\n"),
+	}
+
+	// Act
+	parts := text.Split(30)
+
+	assert.Equal(t, []string{"ma: 08:00-10:00\n", "1234AB\ndi: 08:00-10:00\n"},
+		[]string{parts[0].String(), parts[1].String()})
+}
+
+func TestRichText_SplitLongLine(t *testing.T) {
+	text := RichText{Bold(strings.Repeat("🚚", 5)), Plain("ab\n")}
+
+	// Act
+	parts := text.Split(4)
+
+	assert.Equal(t, []RichText{
+		{Bold("🚚🚚")},
+		{Bold("🚚🚚")},
+		{Bold("🚚"), Plain("ab")},
+		{Plain("\n")},
+	}, parts)
+}
+
+func TestRichText_SplitList(t *testing.T) {
+	text := RichText{List(RichText{Plain("1234AA")}, RichText{Bold("1234AB")})}
+
+	// Act
+	parts := text.Split(10)
+
+	assert.Equal(t, []RichText{
+		{Plain(ListBullet), Plain("1234AA"), Plain("\n")},
+		{Plain(ListBullet), Bold("1234AB"), Plain("\n")},
+	}, parts)
+}
@@ -11,6 +11,40 @@ func (c ChatID) String() string {
 
 // Subscription is a datastructure in DB
 type Subscription struct {
-	ChatID   ChatID
-	Postcode string
+	ChatID    ChatID
+	Postcodes []Postcode
+	// Filter selects slots which are shown and notified
+	Filter Filter
+	// LanguageCode of the user like nl or nl-NL, notifications are sent in it. Empty is the default language
+	LanguageCode string
+}
+
+// HasPostcode reports whether the subscription includes postcode
+func (s *Subscription) HasPostcode(postcode Postcode) bool {
+	for _, p := range s.Postcodes {
+		if p == postcode {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPostcode adds postcode to the subscription. It returns false if the postcode is already there
+func (s *Subscription) AddPostcode(postcode Postcode) bool {
+	if s.HasPostcode(postcode) {
+		return false
+	}
+	s.Postcodes = append(s.Postcodes, postcode)
+	return true
+}
+
+// RemovePostcode removes postcode from the subscription. It returns false if there is no such postcode
+func (s *Subscription) RemovePostcode(postcode Postcode) bool {
+	for i, p := range s.Postcodes {
+		if p == postcode {
+			s.Postcodes = append(s.Postcodes[:i:i], s.Postcodes[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscription_AddRemovePostcode(t *testing.T) {
+	sub := Subscription{ChatID: 1}
+
+	assert.True(t, sub.AddPostcode("1234AA"))
+	assert.True(t, sub.AddPostcode("1234AB"))
+	assert.False(t, sub.AddPostcode("1234AA"))
+	assert.Equal(t, []Postcode{"1234AA", "1234AB"}, sub.Postcodes)
+
+	original := sub.Postcodes
+	assert.True(t, sub.RemovePostcode("1234AA"))
+	assert.False(t, sub.RemovePostcode("1234AA"))
+	assert.Equal(t, []Postcode{"1234AB"}, sub.Postcodes)
+	assert.Equal(t, []Postcode{"1234AA", "1234AB"}, original)
+	assert.True(t, sub.HasPostcode("1234AB"))
+	assert.False(t, sub.HasPostcode("1234AA"))
+}
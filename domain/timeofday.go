@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseTimeOfDay parses "HH:MM" to offset from midnight. "24:00" is the midnight at the end of the day
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse(slotTimeLayout, s)
+	if err != nil {
+		return 0, fmt.Errorf("time '%s' is not in format HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// FormatTimeOfDay formats offset from midnight as "HH:MM", the end of the day is "24:00"
+func FormatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// TimeOfDay returns offset of wall clock time of t from midnight
+func TimeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+}
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"08:30", 8*time.Hour + 30*time.Minute, true},
+		{" 23:00 ", 23 * time.Hour, true},
+		{"00:00", 0, true},
+		{"24:00", 24 * time.Hour, true},
+		{"24:30", 0, false},
+		{"8am", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			// Act
+			d, err := ParseTimeOfDay(tc.input)
+
+			assert.Equal(t, tc.valid, err == nil, "unexpected error %v", err)
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func TestFormatTimeOfDay(t *testing.T) {
+	assert.Equal(t, "08:05", FormatTimeOfDay(8*time.Hour+5*time.Minute))
+	assert.Equal(t, "24:00", FormatTimeOfDay(24*time.Hour))
+}
+
+func TestTimeOfDay(t *testing.T) {
+	// Act
+	d := TimeOfDay(time.Date(2020, 5, 18, 18, 30, 15, 0, time.UTC))
+
+	assert.Equal(t, 18*time.Hour+30*time.Minute+15*time.Second, d)
+}
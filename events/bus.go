@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Handler consumes events. Returned error is reported to the publisher
+type Handler func(ctx context.Context, event Event) error
+
+// Bus dispatches every published event to all subscribers. It is safe for concurrent use
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	order    []int
+	nextID   int
+}
+
+// NewBus creates bus without subscribers
+func NewBus() *Bus {
+	return &Bus{handlers: map[int]Handler{}}
+}
+
+// Subscribe adds handler of all events. Handlers are called in order of subscription.
+// The returned function removes the handler
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	b.order = append(b.order, id)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers, id)
+		for i, subscribed := range b.order {
+			if subscribed == id {
+				b.order = append(b.order[:i:i], b.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Publish synchronously calls all subscribers with event, even if some of them fail.
+// It returns the first error of subscribers, the other errors are logged
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.order))
+	for _, id := range b.order {
+		handlers = append(handlers, b.handlers[id])
+	}
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, handler := range handlers {
+		err := handler(ctx, event)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+			continue
+		}
+		log.Printf("event %s: subscriber failed: %v", event.Name(), err)
+	}
+	return firstErr
+}
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+func TestBus_PublishToAllSubscribers(t *testing.T) {
+	bus := NewBus()
+	received := []string{}
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		received = append(received, "first "+event.Name())
+		return nil
+	})
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		if e, ok := event.(CommandReceived); ok {
+			received = append(received, "second "+e.Message.Text)
+		}
+		return nil
+	})
+
+	// Act
+	err := bus.Publish(context.Background(), CommandReceived{Message: domain.Message{ChatID: 1, Text: "/help"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first command_received", "second /help"}, received)
+}
+
+func TestBus_PublishReturnsFirstError(t *testing.T) {
+	bus := NewBus()
+	errFirst := errors.New("first")
+	calls := 0
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		calls++
+		return errFirst
+	})
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		calls++
+		return errors.New("second")
+	})
+
+	// Act
+	err := bus.Publish(context.Background(), ScanRequested{})
+
+	assert.Equal(t, errFirst, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	bus := NewBus()
+	calls := []int{}
+	unsubscribe := bus.Subscribe(func(ctx context.Context, event Event) error {
+		calls = append(calls, 1)
+		return nil
+	})
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		calls = append(calls, 2)
+		return nil
+	})
+
+	// Act
+	unsubscribe()
+	bus.Publish(context.Background(), ScheduleChanged{Postcode: "1234AA"})
+
+	assert.Equal(t, []int{2}, calls)
+}
+
+func TestBus_ConcurrentPublish(t *testing.T) {
+	bus := NewBus()
+	mu := sync.Mutex{}
+	received := 0
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		mu.Lock()
+		received++
+		mu.Unlock()
+		return nil
+	})
+
+	// Act
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bus.Publish(context.Background(), NotificationSent{ChatID: 1})
+			unsubscribe := bus.Subscribe(func(ctx context.Context, event Event) error { return nil })
+			unsubscribe()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, received)
+}
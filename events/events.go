@@ -0,0 +1,57 @@
+// Package events is the internal event bus of the bot. Telegram and HTTP triggers publish events,
+// the bot and the delivery checker consume them and publish results of their work
+package events
+
+import (
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// Event is published on Bus. Subscribers distinguish events by type
+type Event interface {
+	// Name of event for logs
+	Name() string
+}
+
+// CommandReceived is published when a user sends a message to the bot
+type CommandReceived struct {
+	Message domain.Message
+}
+
+// Name of event
+func (CommandReceived) Name() string { return "command_received" }
+
+// ScanRequested is published when deliveries should be checked. Empty request checks all subscriptions,
+// otherwise either a single postcode or postcodes of a single chat are checked
+type ScanRequested struct {
+	Postcode domain.Postcode
+	ChatID   domain.ChatID
+	// Source of request for logs, e.g. http, pubsub or scheduler
+	Source string
+	// Summary is filled by the checker when the scan is done, if it is set
+	Summary *domain.CheckSummary
+}
+
+// Name of event
+func (ScanRequested) Name() string { return "scan_requested" }
+
+// ScheduleChanged is published when delivery slots of a postcode have changed since the previous check
+type ScheduleChanged struct {
+	Postcode domain.Postcode
+	// Changes is a description of added and removed slots
+	Changes string
+}
+
+// Name of event
+func (ScheduleChanged) Name() string { return "schedule_changed" }
+
+// NotificationSent is published when subscribers of chat are notified about changed schedules
+type NotificationSent struct {
+	ChatID    domain.ChatID
+	Postcodes []domain.Postcode
+	At        time.Time
+}
+
+// Name of event
+func (NotificationSent) Name() string { return "notification_sent" }
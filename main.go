@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/baor/ah-helper-bot/ahhelperbot"
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
+	"github.com/baor/ah-helper-bot/scheduler"
 	"github.com/baor/ah-helper-bot/storage"
 	"github.com/baor/ah-helper-bot/telegram"
+	_ "github.com/lib/pq"
 )
 
 func getBotToken() string {
@@ -31,17 +43,394 @@ func getProjectID() string {
 	return gcProjectID
 }
 
+func getNotifyRemoved() bool {
+	notifyRemoved, _ := strconv.ParseBool(os.Getenv("BOT_NOTIFY_REMOVED"))
+	log.Printf("BOT_NOTIFY_REMOVED: %t", notifyRemoved)
+
+	return notifyRemoved
+}
+
+func getStorage(ctx context.Context) storage.DataStorer {
+	storageType := os.Getenv("BOT_STORAGE")
+	log.Printf("BOT_STORAGE: %s", storageType)
+
+	switch storageType {
+	case "", "firestore":
+		s, err := storage.NewFirestoreAdapter(ctx, getProjectID())
+		if err != nil {
+			log.Panic(err)
+		}
+		return s
+	case "memory":
+		return storage.NewMemoryStorage()
+	case "file":
+		path := os.Getenv("BOT_STORAGE_PATH")
+		if len(path) == 0 {
+			path = "subscriptions.json"
+		}
+		log.Printf("BOT_STORAGE_PATH: %s", path)
+		s, err := storage.NewFileStorage(path)
+		if err != nil {
+			log.Panic(err)
+		}
+		return s
+	case "sql":
+		driver := os.Getenv("BOT_SQL_DRIVER")
+		if len(driver) == 0 {
+			driver = "postgres"
+		}
+		log.Printf("BOT_SQL_DRIVER: %s", driver)
+		db, err := sql.Open(driver, os.Getenv("BOT_SQL_DSN"))
+		if err != nil {
+			log.Panic(err)
+		}
+		s, err := storage.NewSQLStorage(ctx, db)
+		if err != nil {
+			log.Panic(err)
+		}
+		return s
+	}
+
+	log.Panicf("Unknown BOT_STORAGE '%s', expected one of firestore, memory, file, sql", storageType)
+	return nil
+}
+
+func getScheduleCacheTTL() time.Duration {
+	ttl := time.Minute
+	if v := os.Getenv("BOT_SCHEDULE_CACHE_TTL"); len(v) > 0 {
+		var err error
+		ttl, err = time.ParseDuration(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_SCHEDULE_CACHE_TTL '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_SCHEDULE_CACHE_TTL: %s", ttl)
+
+	return ttl
+}
+
+func getCheckWorkers() int {
+	workers := 4
+	if v := os.Getenv("BOT_CHECK_WORKERS"); len(v) > 0 {
+		var err error
+		workers, err = strconv.Atoi(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_CHECK_WORKERS '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_CHECK_WORKERS: %d", workers)
+
+	return workers
+}
+
+// getSlotLocation loads the timezone of AH slots once, so a missing timezone database fails on start
+func getSlotLocation() *time.Location {
+	location, err := time.LoadLocation(domain.SlotTimezone)
+	if err != nil {
+		log.Panicf("Failed to load timezone of slots %s: %v", domain.SlotTimezone, err)
+	}
+	return location
+}
+
+func getAHRequestsPerSecond() float64 {
+	rps := 2.0
+	if v := os.Getenv("BOT_AH_RPS"); len(v) > 0 {
+		var err error
+		rps, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Panicf("Invalid BOT_AH_RPS '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_AH_RPS: %g", rps)
+
+	return rps
+}
+
+func getAHRetries() int {
+	attempts := 3
+	if v := os.Getenv("BOT_AH_RETRIES"); len(v) > 0 {
+		var err error
+		attempts, err = strconv.Atoi(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_AH_RETRIES '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_AH_RETRIES: %d", attempts)
+
+	return attempts
+}
+
+func getAHBreaker() *ahhelperbot.CircuitBreaker {
+	threshold := 5
+	if v := os.Getenv("BOT_AH_BREAKER_THRESHOLD"); len(v) > 0 {
+		var err error
+		threshold, err = strconv.Atoi(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_AH_BREAKER_THRESHOLD '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_AH_BREAKER_THRESHOLD: %d", threshold)
+
+	cooldown := 5 * time.Minute
+	if v := os.Getenv("BOT_AH_BREAKER_COOLDOWN"); len(v) > 0 {
+		var err error
+		cooldown, err = time.ParseDuration(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_AH_BREAKER_COOLDOWN '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_AH_BREAKER_COOLDOWN: %s", cooldown)
+
+	return ahhelperbot.NewCircuitBreaker(threshold, cooldown)
+}
+
+// getCheckScheduler returns scheduler of checks or nil if it is disabled.
+// Checks are done with workCtx, so a check in progress is finished on shutdown
+func getCheckScheduler(workCtx context.Context, bus *events.Bus) *scheduler.Scheduler {
+	v := os.Getenv("BOT_CHECK_INTERVAL")
+	log.Printf("BOT_CHECK_INTERVAL: %s", v)
+	if len(v) == 0 {
+		return nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Panicf("Invalid BOT_CHECK_INTERVAL '%s', expected positive duration like 15m", v)
+	}
+
+	quietHours, err := scheduler.ParseQuietHours(os.Getenv("BOT_QUIET_HOURS"))
+	if err != nil {
+		log.Panicf("Invalid BOT_QUIET_HOURS: %v", err)
+	}
+	log.Printf("BOT_QUIET_HOURS: %s", quietHours)
+
+	timezone := os.Getenv("BOT_TIMEZONE")
+	if len(timezone) == 0 {
+		timezone = "Europe/Amsterdam"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Panicf("Invalid BOT_TIMEZONE '%s': %v", timezone, err)
+	}
+	log.Printf("BOT_TIMEZONE: %s", location)
+
+	s := scheduler.New(func(context.Context) {
+		var summary domain.CheckSummary
+		err := bus.Publish(workCtx, events.ScanRequested{Source: "scheduler", Summary: &summary})
+		if err != nil {
+			log.Printf("Scheduled check failed: %v, %s", err, summary)
+			return
+		}
+		log.Printf("Scheduled check is done: %s", summary)
+	}, interval)
+	s.SetQuietHours(quietHours, location)
+	return s
+}
+
+func getMessenger(bus *events.Bus) telegram.Messenger {
+	mode := os.Getenv("BOT_TELEGRAM_MODE")
+	log.Printf("BOT_TELEGRAM_MODE: %s", mode)
+
+	parseMode := getTelegramParseMode()
+	switch mode {
+	case "", "polling":
+		return telegram.NewMessenger(getBotToken(), bus, parseMode)
+	case "webhook":
+		webhookURL := os.Getenv("BOT_TELEGRAM_WEBHOOK_URL")
+		log.Printf("BOT_TELEGRAM_WEBHOOK_URL: %s", webhookURL)
+		u, err := url.Parse(webhookURL)
+		if err != nil || len(u.Host) == 0 || len(u.Path) <= 1 {
+			log.Panicf("Invalid BOT_TELEGRAM_WEBHOOK_URL '%s', expected URL with path like https://example.com/telegram", webhookURL)
+		}
+		secretToken := os.Getenv("BOT_TELEGRAM_SECRET_TOKEN")
+		if len(secretToken) == 0 {
+			log.Panic("Empty BOT_TELEGRAM_SECRET_TOKEN")
+		}
+
+		messenger, handler := telegram.NewWebhookMessenger(getBotToken(), bus, webhookURL, secretToken, parseMode)
+		http.Handle(u.Path, handler)
+		return messenger
+	}
+
+	log.Panicf("Unknown BOT_TELEGRAM_MODE '%s', expected one of polling, webhook", mode)
+	return nil
+}
+
+func getTelegramParseMode() telegram.ParseMode {
+	mode := os.Getenv("BOT_TELEGRAM_PARSE_MODE")
+	log.Printf("BOT_TELEGRAM_PARSE_MODE: %s", mode)
+
+	switch mode {
+	case "", string(telegram.ParseModeMarkdownV2):
+		return telegram.ParseModeMarkdownV2
+	case string(telegram.ParseModeHTML):
+		return telegram.ParseModeHTML
+	}
+
+	log.Panicf("Unknown BOT_TELEGRAM_PARSE_MODE '%s', expected one of MarkdownV2, HTML", mode)
+	return ""
+}
+
+// logEvent logs changed schedules and sent notifications
+func logEvent(ctx context.Context, event events.Event) error {
+	switch e := event.(type) {
+	case events.ScheduleChanged:
+		log.Printf("event %s: postcode %s", e.Name(), e.Postcode)
+	case events.NotificationSent:
+		log.Printf("event %s: chat %s, postcodes %v", e.Name(), e.ChatID, e.Postcodes)
+	}
+	return nil
+}
+
+func getTelegramMessagesPerSecond() float64 {
+	rps := 30.0
+	if v := os.Getenv("BOT_TELEGRAM_RPS"); len(v) > 0 {
+		var err error
+		rps, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Panicf("Invalid BOT_TELEGRAM_RPS '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_TELEGRAM_RPS: %g", rps)
+
+	return rps
+}
+
+// storageCloseTimeout is the budget of closing the storage after the shutdown deadline
+const storageCloseTimeout = time.Second
+
+func getShutdownTimeout() time.Duration {
+	timeout := 8 * time.Second
+	if v := os.Getenv("BOT_SHUTDOWN_TIMEOUT"); len(v) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			log.Panicf("Invalid BOT_SHUTDOWN_TIMEOUT '%s': %v", v, err)
+		}
+	}
+	log.Printf("BOT_SHUTDOWN_TIMEOUT: %s", timeout)
+
+	return timeout
+}
+
+// notifySignal returns context which is done on SIGTERM or SIGINT
+func notifySignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("Signal %s is received, shutting down", sig)
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
 func main() {
-	s := storage.NewFirestoreAdapter(getProjectID())
-	bot := ahhelperbot.NewBot(s, &ahhelperbot.DefaultDeliveryProvider{})
-	telegramMessenger := telegram.NewMessenger(getBotToken(), bot.DefaultMessageProcessor, 5*time.Second)
+	// ctx is done on signal: no new updates, scheduled checks and HTTP requests are taken.
+	// Work in progress is finished with workCtx, which is done on the shutdown deadline
+	ctx := notifySignal()
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	shutdownTimeout := getShutdownTimeout()
+
+	s := getStorage(context.Background())
+	breaker := getAHBreaker()
+	var deliveryProvider ahhelperbot.DeliveryProvider = &ahhelperbot.DefaultDeliveryProvider{
+		Retry: ahhelperbot.RetryPolicy{
+			MaxAttempts: getAHRetries(),
+			BaseDelay:   time.Second,
+			MaxDelay:    30 * time.Second,
+		},
+		Breaker:  breaker,
+		Limiter:  ahhelperbot.NewRequestLimiter(getAHRequestsPerSecond()),
+		Location: getSlotLocation(),
+	}
+	deliveryProvider = ahhelperbot.NewCachedDeliveryProvider(deliveryProvider, getScheduleCacheTTL())
+	bot := ahhelperbot.NewBot(s, deliveryProvider)
+	bot.SetWorkers(getCheckWorkers())
+	bot.SetNotifyRemoved(getNotifyRemoved())
+	bus := events.NewBus()
+	bus.Subscribe(logEvent)
+	bot.SetEventBus(bus)
+	// replies and notifications are sent by the queue, which keeps limits of telegram
+	telegramMessenger := telegram.NewQueue(getMessenger(bus), getTelegramMessagesPerSecond(), time.Second)
 	bot.SetMessenger(telegramMessenger)
+	bot.SetBotName(telegramMessenger.UserName())
+	if err := bot.PublishCommands(); err != nil {
+		log.Printf("Commands are not published: %v", err)
+	}
+
+	running := sync.WaitGroup{}
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		telegramMessenger.Run(ctx)
+	}()
+	if checkScheduler := getCheckScheduler(workCtx, bus); checkScheduler != nil {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			checkScheduler.Run(ctx)
+		}()
+	}
 
 	http.HandleFunc("/check_deliveries", func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("check_deliveries request is received")
-		bot.CheckDeliveries()
-		log.Printf("check_deliveries is done")
-		fmt.Fprint(w, "check_deliveries is done")
+		var summary domain.CheckSummary
+		err := bus.Publish(r.Context(), events.ScanRequested{Source: "http", Summary: &summary})
+		replies, broadcasts := telegramMessenger.Depth()
+		log.Printf("Outgoing queue: %d replies, %d broadcasts", replies, broadcasts)
+		if state := breaker.State(); state != ahhelperbot.BreakerClosed {
+			log.Printf("AH checks are paused, circuit breaker is %s until %s", state, breaker.ResumeAt())
+		}
+		if err != nil {
+			log.Printf("check_deliveries failed: %v, %s", err, summary)
+			http.Error(w, fmt.Sprintf("check_deliveries failed: %s", summary), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("check_deliveries is done: %s", summary)
+		fmt.Fprintf(w, "check_deliveries is done: %s", summary)
 	})
-	http.ListenAndServe(":8080", nil)
+	http.Handle("/pubsub", ahhelperbot.NewPubSubHandler(bus))
+	server := &http.Server{
+		Addr:        ":8080",
+		BaseContext: func(net.Listener) context.Context { return workCtx },
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Panic(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	go func() {
+		<-shutdownCtx.Done()
+		cancelWork()
+	}()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server is not shut down gracefully: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Printf("Messenger and scheduler are not stopped in %s", shutdownTimeout)
+	}
+
+	// queued notifications are sent until the shutdown deadline, the storage has its own budget after it
+	telegramMessenger.Close(shutdownCtx)
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), storageCloseTimeout)
+	defer cancelClose()
+	if err := s.Close(closeCtx); err != nil {
+		log.Printf("Storage is not closed: %v", err)
+	}
+	log.Printf("Bot is stopped")
 }
@@ -0,0 +1,7 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// SQLite driver requires cgo, so it is included only with build tag sqlite
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// QuietHours is a daily period without scheduled runs. Start and End are offsets from midnight,
+// the period wraps over midnight if End is before Start, End can be 24:00. Zero value has no quiet hours
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseQuietHours parses period like "01:00-06:00" or "22:00-24:00". Empty string means no quiet hours
+func ParseQuietHours(s string) (QuietHours, error) {
+	if len(s) == 0 {
+		return QuietHours{}, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return QuietHours{}, fmt.Errorf("quiet hours '%s' are not in format HH:MM-HH:MM", s)
+	}
+	start, err := domain.ParseTimeOfDay(parts[0])
+	if err != nil {
+		return QuietHours{}, err
+	}
+	end, err := domain.ParseTimeOfDay(parts[1])
+	if err != nil {
+		return QuietHours{}, err
+	}
+	return QuietHours{Start: start, End: end}, nil
+}
+
+// Contains reports whether wall clock time of t is within quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.Start == q.End {
+		return false
+	}
+	offset := domain.TimeOfDay(t)
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+func (q QuietHours) String() string {
+	return domain.FormatTimeOfDay(q.Start) + "-" + domain.FormatTimeOfDay(q.End)
+}
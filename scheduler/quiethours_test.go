@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected QuietHours
+		valid    bool
+	}{
+		{"", QuietHours{}, true},
+		{"01:00-06:00", QuietHours{Start: time.Hour, End: 6 * time.Hour}, true},
+		{"23:30 - 06:15", QuietHours{Start: 23*time.Hour + 30*time.Minute, End: 6*time.Hour + 15*time.Minute}, true},
+		{"22:00-24:00", QuietHours{Start: 22 * time.Hour, End: 24 * time.Hour}, true},
+		{"01:00", QuietHours{}, false},
+		{"1am-6am", QuietHours{}, false},
+		{"25:00-06:00", QuietHours{}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			// Act
+			q, err := ParseQuietHours(tc.value)
+
+			if tc.valid {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, q)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestQuietHours_Contains(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 4, 6, hour, min, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		name     string
+		quiet    string
+		t        time.Time
+		expected bool
+	}{
+		{"no quiet hours", "", at(3, 0), false},
+		{"before", "01:00-06:00", at(0, 59), false},
+		{"start", "01:00-06:00", at(1, 0), true},
+		{"inside", "01:00-06:00", at(3, 0), true},
+		{"end", "01:00-06:00", at(6, 0), false},
+		{"over midnight before", "23:00-06:00", at(23, 30), true},
+		{"over midnight after", "23:00-06:00", at(5, 59), true},
+		{"over midnight outside", "23:00-06:00", at(12, 0), false},
+		{"until midnight", "22:00-24:00", at(23, 59), true},
+		{"after midnight", "22:00-24:00", at(0, 0), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := ParseQuietHours(tc.quiet)
+			assert.NoError(t, err)
+
+			// Act
+			contains := q.Contains(tc.t)
+
+			assert.Equal(t, tc.expected, contains)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Package scheduler runs periodic jobs inside the bot process, e.g. delivery checks for self-hosted setups
+// without an external trigger of /check_deliveries
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Clock provides time to Scheduler. It is replaced by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Job is a function run by Scheduler
+type Job func(ctx context.Context)
+
+// Scheduler runs a job every interval except of quiet hours.
+// A new run is never started while the previous one is still going
+type Scheduler struct {
+	job      Job
+	interval time.Duration
+	quiet    QuietHours
+	location *time.Location
+	clock    Clock
+
+	mu      sync.Mutex
+	running bool
+	wg      sync.WaitGroup
+}
+
+// New creates scheduler which runs job every interval
+func New(job Job, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		job:      job,
+		interval: interval,
+		location: time.UTC,
+		clock:    realClock{},
+	}
+}
+
+// SetQuietHours sets hours without runs. The hours are interpreted in location
+func (s *Scheduler) SetQuietHours(quiet QuietHours, location *time.Location) {
+	s.quiet = quiet
+	s.location = location
+}
+
+// SetClock replaces the real clock
+func (s *Scheduler) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// Run starts the job immediately and then every interval until ctx is done.
+// It returns after the current run is finished
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.wg.Wait()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
+
+// tick starts a run unless it is quiet hours or the previous run is still going
+func (s *Scheduler) tick(ctx context.Context) {
+	now := s.clock.Now().In(s.location)
+	if s.quiet.Contains(now) {
+		log.Printf("Scheduled run is skipped during quiet hours %s", s.quiet)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		log.Printf("Scheduled run is skipped, the previous run is still going")
+		return
+	}
+	s.running = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			s.running = false
+			s.mu.Unlock()
+		}()
+		s.job(ctx)
+	}()
+}
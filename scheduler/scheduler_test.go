@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock fires timers only when time is advanced by the test
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	created chan struct{}
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, created: make(chan struct{}, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	c.created <- struct{}{}
+	return timer.c
+}
+
+// waitForTimer blocks until the scheduler waits for the next tick
+func (c *fakeClock) waitForTimer(t *testing.T) {
+	select {
+	case <-c.created:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler does not wait for the next tick")
+	}
+}
+
+// Advance moves time forward and fires due timers
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+func receive(t *testing.T, runs <-chan struct{}) {
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Fatal("job is not run")
+	}
+}
+
+func startScheduler(t *testing.T, s *Scheduler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestScheduler_RunsEveryInterval(t *testing.T) {
+	clock := newFakeClock(time.Date(2020, 4, 6, 12, 0, 0, 0, time.UTC))
+	runs := make(chan struct{}, 10)
+	s := New(func(ctx context.Context) { runs <- struct{}{} }, time.Minute)
+	s.SetClock(clock)
+
+	// Act
+	startScheduler(t, s)
+
+	receive(t, runs)
+	clock.waitForTimer(t)
+	clock.Advance(30 * time.Second)
+	assert.Len(t, runs, 0)
+	clock.Advance(30 * time.Second)
+	receive(t, runs)
+}
+
+func TestScheduler_SkipsQuietHours(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("time zone database is not available: %v", err)
+	}
+	clock := newFakeClock(time.Date(2020, 4, 6, 5, 0, 0, 0, amsterdam))
+	runs := make(chan struct{}, 10)
+	s := New(func(ctx context.Context) { runs <- struct{}{} }, time.Hour)
+	s.SetClock(clock)
+	s.SetQuietHours(QuietHours{Start: time.Hour, End: 6 * time.Hour}, amsterdam)
+
+	// Act
+	startScheduler(t, s)
+
+	clock.waitForTimer(t)
+	assert.Len(t, runs, 0)
+	clock.Advance(time.Hour)
+	receive(t, runs)
+}
+
+func TestScheduler_NoOverlappingRuns(t *testing.T) {
+	clock := newFakeClock(time.Date(2020, 4, 6, 12, 0, 0, 0, time.UTC))
+	runs := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	s := New(func(ctx context.Context) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		runs <- struct{}{}
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}, time.Minute)
+	s.SetClock(clock)
+
+	// Act
+	startScheduler(t, s)
+
+	receive(t, runs)
+	clock.waitForTimer(t)
+	clock.Advance(time.Minute)
+	clock.waitForTimer(t)
+	assert.Len(t, runs, 0)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return !s.running
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Minute)
+	receive(t, runs)
+	mu.Lock()
+	assert.Equal(t, 1, maxActive)
+	mu.Unlock()
+}
+
+func TestScheduler_RunWaitsForCurrentRun(t *testing.T) {
+	clock := newFakeClock(time.Date(2020, 4, 6, 12, 0, 0, 0, time.UTC))
+	started := make(chan struct{})
+	finished := false
+	s := New(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		finished = true
+	}, time.Minute)
+	s.SetClock(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	<-started
+
+	// Act
+	cancel()
+
+	<-done
+	assert.True(t, finished)
+}
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baor/ah-helper-bot/storage"
+	"github.com/baor/ah-helper-bot/storage/storagetest"
+)
+
+func TestConformance_Memory(t *testing.T) {
+	storagetest.TestDataStorer(t, func(t *testing.T) storage.DataStorer {
+		return storage.NewMemoryStorage()
+	})
+}
+
+func TestConformance_File(t *testing.T) {
+	storagetest.TestDataStorer(t, func(t *testing.T) storage.DataStorer {
+		dir, err := ioutil.TempDir("", "storage")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		s, err := storage.NewFileStorage(filepath.Join(dir, "subscriptions.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestConformance_SQL(t *testing.T) {
+	storagetest.TestDataStorer(t, func(t *testing.T) storage.DataStorer {
+		s, err := storage.NewSQLStorage(context.Background(), storage.OpenTestSQLite(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// TestConformance_Firestore runs against Firestore emulator, e.g.
+// gcloud beta emulators firestore start --host-port=localhost:8081
+// FIRESTORE_EMULATOR_HOST=localhost:8081 go test ./storage/
+func TestConformance_Firestore(t *testing.T) {
+	if len(os.Getenv("FIRESTORE_EMULATOR_HOST")) == 0 {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	storagetest.TestDataStorer(t, func(t *testing.T) storage.DataStorer {
+		ctx := context.Background()
+		collection := fmt.Sprintf("subscriptions-%d", time.Now().UnixNano())
+		s, err := storage.NewFirestoreAdapterWithCollection(ctx, "ah-helper-bot-test", collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			subs, _ := s.GetSubscriptions(ctx)
+			for _, sub := range subs {
+				s.RemoveSubscription(ctx, sub)
+			}
+		})
+		return s
+	})
+}
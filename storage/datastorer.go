@@ -1,13 +1,64 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/baor/ah-helper-bot/domain"
 )
 
+// ErrNotFound is returned when subscription doesn't exist in the storage
+var ErrNotFound = errors.New("subscription not found")
+
 // DataStorer to store chats and postcodes
 type DataStorer interface {
-	AddSubscription(domain.Subscription)
-	GetSubscriptionByID(domain.ChatID) domain.Subscription
-	RemoveSubscription(domain.Subscription)
-	GetSubscriptions() []domain.Subscription
+	AddSubscription(context.Context, domain.Subscription) error
+	GetSubscriptionByID(context.Context, domain.ChatID) (domain.Subscription, error)
+	RemoveSubscription(context.Context, domain.Subscription) error
+	GetSubscriptions(context.Context) ([]domain.Subscription, error)
+	// Close releases clients of the storage, it gives up waiting when ctx is done.
+	// The storage must not be used after Close
+	Close(ctx context.Context) error
+}
+
+// closeWithContext calls close and waits for it until ctx is done
+func closeWithContext(ctx context.Context, close func() error) error {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- close()
+	}()
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NotificationRecorder is implemented by storages which keep track of notifications sent to chats
+type NotificationRecorder interface {
+	MarkNotified(ctx context.Context, chatID domain.ChatID, at time.Time) error
+	// GetLastNotified returns zero time if the chat was never notified
+	GetLastNotified(ctx context.Context, chatID domain.ChatID) (time.Time, error)
+}
+
+// Actions of subscription history
+const (
+	ActionSubscribed   = "subscribed"
+	ActionUnsubscribed = "unsubscribed"
+)
+
+// SubscriptionEvent is a record of subscription history
+type SubscriptionEvent struct {
+	ChatID   domain.ChatID
+	Postcode domain.Postcode
+	Action   string
+	At       time.Time
+}
+
+// HistoryStorer is implemented by storages which keep history of subscriptions
+type HistoryStorer interface {
+	// GetSubscriptionHistory returns events of the chat from the oldest to the newest
+	GetSubscriptionHistory(ctx context.Context, chatID domain.ChatID) ([]SubscriptionEvent, error)
 }
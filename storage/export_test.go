@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var NewFirestoreAdapterWithCollection = newFirestoreAdapter
+
+// OpenTestSQLite opens SQLite database in a temporary directory which is removed on test cleanup
+func OpenTestSQLite(t *testing.T) *sql.DB {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "bot.db")+"?_busy_timeout=5000")
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		t.Skipf("SQLite is not available: %v", err)
+	}
+	// SQLite allows only one writer, concurrent writes are serialized by database/sql
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
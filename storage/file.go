@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// fileStorage keeps subscriptions in memory and persists them to a JSON file on every change.
+// It is safe for concurrent use within one process
+type fileStorage struct {
+	mu            sync.RWMutex
+	path          string
+	subscriptions map[domain.ChatID]domain.Subscription
+}
+
+// NewFileStorage creates storage which keeps subscriptions in the JSON file.
+// The file is created on the first change if it doesn't exist
+func NewFileStorage(path string) (DataStorer, error) {
+	s := fileStorage{
+		path:          path,
+		subscriptions: map[domain.ChatID]domain.Subscription{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Storage file '%s' doesn't exist, start with empty storage", path)
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage file '%s': %w", path, err)
+	}
+
+	subs := []storedSubscription{}
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, fmt.Errorf("failed to parse storage file '%s': %w", path, err)
+	}
+	for _, sub := range subs {
+		s.subscriptions[sub.ChatID] = sub.toDomain()
+	}
+
+	log.Printf("%d subscriptions are loaded from '%s'", len(subs), path)
+	return &s, nil
+}
+
+func (s *fileStorage) AddSubscription(ctx context.Context, sub domain.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.subscriptions[sub.ChatID]
+	s.subscriptions[sub.ChatID] = copySubscription(sub)
+	if err := s.save(); err != nil {
+		if existed {
+			s.subscriptions[sub.ChatID] = prev
+		} else {
+			delete(s.subscriptions, sub.ChatID)
+		}
+		return fmt.Errorf("error on adding subscription %+v: %w", sub, err)
+	}
+	return nil
+}
+
+func (s *fileStorage) RemoveSubscription(ctx context.Context, sub domain.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.subscriptions[sub.ChatID]
+	if !existed {
+		return nil
+	}
+	delete(s.subscriptions, sub.ChatID)
+	if err := s.save(); err != nil {
+		s.subscriptions[sub.ChatID] = prev
+		return fmt.Errorf("error on removing subscription %+v: %w", sub, err)
+	}
+	return nil
+}
+
+func (s *fileStorage) GetSubscriptions(ctx context.Context) ([]domain.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedSubscriptions(s.subscriptions), nil
+}
+
+func (s *fileStorage) GetSubscriptionByID(ctx context.Context, chatID domain.ChatID) (domain.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subscriptions[chatID]
+	if !ok {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return copySubscription(sub), nil
+}
+
+// save writes all subscriptions to a temporary file and replaces the storage file with it,
+// so the storage file is never left half-written
+func (s *fileStorage) save() error {
+	subs := []storedSubscription{}
+	for _, sub := range sortedSubscriptions(s.subscriptions) {
+		subs = append(subs, newStoredSubscription(sub))
+	}
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Close does nothing, the file is written and closed on every change
+func (s *fileStorage) Close(ctx context.Context) error {
+	return nil
+}
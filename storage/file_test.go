@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorage_Persist(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.json")
+
+	s, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}}))
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}}))
+	assert.NoError(t, s.RemoveSubscription(ctx, domain.Subscription{ChatID: 2}))
+
+	// Act
+	reopened, err := NewFileStorage(path)
+
+	assert.NoError(t, err)
+	subs, err := reopened.GetSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Subscription{{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}}}, subs)
+	_, err = reopened.GetSubscriptionByID(ctx, 2)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestFileStorage_InvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+
+	// Act
+	_, err = NewFileStorage(path)
+
+	assert.Error(t, err)
+}
+
+func TestFileStorage_SinglePostcodeFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"ChatID": 1, "Postcode": "1234AA"}]`), 0600))
+
+	// Act
+	s, err := NewFileStorage(path)
+
+	assert.NoError(t, err)
+	sub, err := s.GetSubscriptionByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Postcode{"1234AA"}, sub.Postcodes)
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"cloud.google.com/go/firestore"
 	fs "cloud.google.com/go/firestore"
@@ -14,47 +16,53 @@ import (
 )
 
 type firestoreAdapter struct {
-	client  *fs.Client
-	context context.Context
+	client     *fs.Client
+	collection string
 }
 
 const subscriptionCollection = "subscriptions"
 
 // NewFirestoreAdapter creates new adapter
-func NewFirestoreAdapter(projectID string) DataStorer {
-	ctx := context.Background()
+func NewFirestoreAdapter(ctx context.Context, projectID string) (DataStorer, error) {
+	return newFirestoreAdapter(ctx, projectID, subscriptionCollection)
+}
+
+func newFirestoreAdapter(ctx context.Context, projectID string, collection string) (DataStorer, error) {
 	client, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
 	}
 
 	adapater := firestoreAdapter{
-		client:  client,
-		context: ctx,
+		client:     client,
+		collection: collection,
 	}
 
 	log.Printf("Firestore client to project '%s' is created", projectID)
-	return &adapater
+	return &adapater, nil
 }
 
-func (a *firestoreAdapter) AddSubscription(sub domain.Subscription) {
+func (a *firestoreAdapter) AddSubscription(ctx context.Context, sub domain.Subscription) error {
 	log.Printf("Add subscription %+v", sub)
-	_, err := a.client.Collection(subscriptionCollection).Doc(sub.ChatID.String()).Set(a.context, sub)
+	_, err := a.client.Collection(a.collection).Doc(sub.ChatID.String()).Set(ctx, newStoredSubscription(sub))
 	if err != nil {
-		log.Fatalf("Error %v on adding subscription: %v+", err, sub)
+		return fmt.Errorf("error on adding subscription %+v: %w", sub, err)
 	}
+	return nil
 }
 
-func (a *firestoreAdapter) RemoveSubscription(sub domain.Subscription) {
+func (a *firestoreAdapter) RemoveSubscription(ctx context.Context, sub domain.Subscription) error {
 	log.Printf("Remove subscription %+v", sub)
-	_, err := a.client.Collection(subscriptionCollection).Doc(sub.ChatID.String()).Delete(a.context)
+	_, err := a.client.Collection(a.collection).Doc(sub.ChatID.String()).Delete(ctx)
 	if err != nil {
-		log.Fatalf("Error %v on removing subscription: %v+", err, sub)
+		return fmt.Errorf("error on removing subscription %+v: %w", sub, err)
 	}
+	return nil
 }
 
-func (a *firestoreAdapter) GetSubscriptions() []domain.Subscription {
-	iter := a.client.Collection(subscriptionCollection).Documents(a.context)
+func (a *firestoreAdapter) GetSubscriptions(ctx context.Context) ([]domain.Subscription, error) {
+	iter := a.client.Collection(a.collection).Documents(ctx)
+	defer iter.Stop()
 	subs := []domain.Subscription{}
 	for {
 		doc, err := iter.Next()
@@ -62,28 +70,34 @@ func (a *firestoreAdapter) GetSubscriptions() []domain.Subscription {
 			break
 		}
 		if err != nil {
-			log.Fatalf("Failed to iterate: %v", err)
+			return nil, fmt.Errorf("failed to iterate subscriptions: %w", err)
 		}
-		fmt.Println(doc.Data())
 
-		var sub domain.Subscription
+		var sub storedSubscription
 		if err := doc.DataTo(&sub); err != nil {
-			log.Fatalf("Error when convering data from storage: %v", err)
-			return subs
+			return nil, fmt.Errorf("error when converting data from storage: %w", err)
 		}
-		subs = append(subs, sub)
+		subs = append(subs, sub.toDomain())
 	}
-	return subs
+	return subs, nil
 }
 
-func (a *firestoreAdapter) GetSubscriptionByID(chatID domain.ChatID) domain.Subscription {
-	doc, err := a.client.Collection(subscriptionCollection).Doc(chatID.String()).Get(a.context)
+func (a *firestoreAdapter) GetSubscriptionByID(ctx context.Context, chatID domain.ChatID) (domain.Subscription, error) {
+	doc, err := a.client.Collection(a.collection).Doc(chatID.String()).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return domain.Subscription{}, ErrNotFound
+	}
 	if err != nil {
-		log.Fatalf("Failed to get subscription by %v", chatID)
+		return domain.Subscription{}, fmt.Errorf("failed to get subscription by %v: %w", chatID, err)
 	}
-	var sub domain.Subscription
+	var sub storedSubscription
 	if err := doc.DataTo(&sub); err != nil {
-		log.Fatalf("Error when convering data from storage: %v", err)
+		return domain.Subscription{}, fmt.Errorf("error when converting data from storage: %w", err)
 	}
-	return sub
+	return sub.toDomain(), nil
+}
+
+// Close closes the firestore client
+func (a *firestoreAdapter) Close(ctx context.Context) error {
+	return closeWithContext(ctx, a.client.Close)
 }
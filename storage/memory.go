@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// memoryStorage keeps subscriptions in memory. It is safe for concurrent use
+type memoryStorage struct {
+	mu            sync.RWMutex
+	subscriptions map[domain.ChatID]domain.Subscription
+}
+
+// NewMemoryStorage creates storage which keeps subscriptions in memory.
+// Subscriptions are lost on restart
+func NewMemoryStorage() DataStorer {
+	return &memoryStorage{
+		subscriptions: map[domain.ChatID]domain.Subscription{},
+	}
+}
+
+// Close does nothing, memory storage has no clients
+func (s *memoryStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStorage) AddSubscription(ctx context.Context, sub domain.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.ChatID] = copySubscription(sub)
+	return nil
+}
+
+func (s *memoryStorage) RemoveSubscription(ctx context.Context, sub domain.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, sub.ChatID)
+	return nil
+}
+
+func (s *memoryStorage) GetSubscriptions(ctx context.Context) ([]domain.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedSubscriptions(s.subscriptions), nil
+}
+
+func (s *memoryStorage) GetSubscriptionByID(ctx context.Context, chatID domain.ChatID) (domain.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subscriptions[chatID]
+	if !ok {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return copySubscription(sub), nil
+}
+
+// sortedSubscriptions returns subscriptions ordered by chat ID
+func sortedSubscriptions(subscriptions map[domain.ChatID]domain.Subscription) []domain.Subscription {
+	subs := make([]domain.Subscription, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		subs = append(subs, copySubscription(sub))
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].ChatID < subs[j].ChatID
+	})
+	return subs
+}
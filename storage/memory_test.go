@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage_AddGetRemove(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+
+	// Act
+	err := s.AddSubscription(ctx, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}})
+	assert.NoError(t, err)
+	err = s.AddSubscription(ctx, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AB"}})
+	assert.NoError(t, err)
+
+	sub, err := s.GetSubscriptionByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}}, sub)
+
+	subs, err := s.GetSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Subscription{
+		{ChatID: 1, Postcodes: []domain.Postcode{"1234AB"}},
+		{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}},
+	}, subs)
+
+	err = s.RemoveSubscription(ctx, domain.Subscription{ChatID: 2})
+	assert.NoError(t, err)
+
+	_, err = s.GetSubscriptionByID(ctx, 2)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+
+	// Act
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(chatID domain.ChatID) {
+			defer wg.Done()
+			s.AddSubscription(ctx, domain.Subscription{ChatID: chatID, Postcodes: []domain.Postcode{"1234AA"}})
+			s.GetSubscriptions(ctx)
+		}(domain.ChatID(i))
+	}
+	wg.Wait()
+
+	subs, err := s.GetSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 50)
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// sqlMigrations are versioned schema migrations. Version of a migration is its index + 1.
+// Applied migrations must never be changed, add a new one instead.
+// Statements must be compatible with PostgreSQL and SQLite
+var sqlMigrations = [][]string{
+	// 1: subscriptions and their history
+	{
+		`CREATE TABLE subscriptions (
+			chat_id BIGINT PRIMARY KEY,
+			postcode TEXT NOT NULL,
+			subscribed_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			last_notified_at TIMESTAMP NULL
+		)`,
+		`CREATE TABLE subscription_history (
+			chat_id BIGINT NOT NULL,
+			postcode TEXT NOT NULL,
+			action TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX subscription_history_chat_id ON subscription_history (chat_id)`,
+	},
+	// 2: multiple postcodes per chat. subscriptions.postcode is kept empty for new subscriptions
+	{
+		`CREATE TABLE subscription_postcodes (
+			chat_id BIGINT NOT NULL,
+			position INTEGER NOT NULL,
+			postcode TEXT NOT NULL,
+			PRIMARY KEY (chat_id, postcode)
+		)`,
+		`INSERT INTO subscription_postcodes (chat_id, position, postcode)
+			SELECT chat_id, 0, postcode FROM subscriptions WHERE postcode <> ''`,
+		`UPDATE subscriptions SET postcode = ''`,
+	},
+	// 3: filters of slots, JSON of storedFilter or empty
+	{
+		`ALTER TABLE subscriptions ADD COLUMN filter TEXT NOT NULL DEFAULT ''`,
+	},
+	// 4: language of notifications, empty for the default language
+	{
+		`ALTER TABLE subscriptions ADD COLUMN language_code TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// sqlStorage keeps subscriptions in SQL database
+type sqlStorage struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewSQLStorage creates storage on top of db and applies pending schema migrations.
+// PostgreSQL and SQLite are supported. The storage owns db and closes it on Close
+func NewSQLStorage(ctx context.Context, db *sql.DB) (DataStorer, error) {
+	s := sqlStorage{
+		db:  db,
+		now: func() time.Time { return time.Now().UTC() },
+	}
+	if err := s.migrate(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+	}
+	return &s, nil
+}
+
+func (s *sqlStorage) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for i := current; i < len(sqlMigrations); i++ {
+		version := i + 1
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			for _, stmt := range sqlMigrations[i] {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, version, s.now())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		log.Printf("Database schema is migrated to version %d", version)
+	}
+	return nil
+}
+
+func (s *sqlStorage) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStorage) AddSubscription(ctx context.Context, sub domain.Subscription) error {
+	log.Printf("Add subscription %+v", sub)
+	now := s.now()
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		prev, err := s.getPostcodes(ctx, tx, sub.ChatID)
+		if err != nil {
+			return err
+		}
+
+		filter, err := marshalFilter(sub.Filter)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO subscriptions (chat_id, postcode, subscribed_at, updated_at, filter, language_code)
+			VALUES ($1, '', $2, $2, $3, $4)
+			ON CONFLICT (chat_id) DO UPDATE SET postcode = '', updated_at = excluded.updated_at,
+				filter = excluded.filter, language_code = excluded.language_code`,
+			int64(sub.ChatID), now, filter, sub.LanguageCode)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM subscription_postcodes WHERE chat_id = $1`, int64(sub.ChatID)); err != nil {
+			return err
+		}
+		for i, postcode := range sub.Postcodes {
+			_, err := tx.ExecContext(ctx, `INSERT INTO subscription_postcodes (chat_id, position, postcode)
+				VALUES ($1, $2, $3)`, int64(sub.ChatID), i, postcode)
+			if err != nil {
+				return err
+			}
+		}
+
+		prevSub := domain.Subscription{ChatID: sub.ChatID, Postcodes: prev}
+		for _, postcode := range sub.Postcodes {
+			if prevSub.HasPostcode(postcode) {
+				continue
+			}
+			if err := s.addHistory(ctx, tx, sub.ChatID, postcode, ActionSubscribed, now); err != nil {
+				return err
+			}
+		}
+		for _, postcode := range prev {
+			if sub.HasPostcode(postcode) {
+				continue
+			}
+			if err := s.addHistory(ctx, tx, sub.ChatID, postcode, ActionUnsubscribed, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on adding subscription %+v: %w", sub, err)
+	}
+	return nil
+}
+
+func (s *sqlStorage) RemoveSubscription(ctx context.Context, sub domain.Subscription) error {
+	log.Printf("Remove subscription %+v", sub)
+	now := s.now()
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		prev, err := s.getPostcodes(ctx, tx, sub.ChatID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM subscription_postcodes WHERE chat_id = $1`, int64(sub.ChatID)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM subscriptions WHERE chat_id = $1`, int64(sub.ChatID)); err != nil {
+			return err
+		}
+		for _, postcode := range prev {
+			if err := s.addHistory(ctx, tx, sub.ChatID, postcode, ActionUnsubscribed, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on removing subscription %+v: %w", sub, err)
+	}
+	return nil
+}
+
+func (s *sqlStorage) getPostcodes(ctx context.Context, tx *sql.Tx, chatID domain.ChatID) ([]domain.Postcode, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT postcode FROM subscription_postcodes
+		WHERE chat_id = $1 ORDER BY position`, int64(chatID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postcodes := []domain.Postcode{}
+	for rows.Next() {
+		var postcode domain.Postcode
+		if err := rows.Scan(&postcode); err != nil {
+			return nil, err
+		}
+		postcodes = append(postcodes, postcode)
+	}
+	return postcodes, rows.Err()
+}
+
+func (s *sqlStorage) addHistory(ctx context.Context, tx *sql.Tx, chatID domain.ChatID, postcode domain.Postcode, action string, at time.Time) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO subscription_history (chat_id, postcode, action, created_at)
+		VALUES ($1, $2, $3, $4)`, int64(chatID), postcode, action, at)
+	return err
+}
+
+// marshalFilter returns JSON of filter or empty string for empty filter
+func marshalFilter(filter domain.Filter) (string, error) {
+	stored := newStoredFilter(filter)
+	if stored == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unmarshalFilter returns filter of chat stored as JSON. Invalid filter is skipped
+func unmarshalFilter(chatID domain.ChatID, data string) domain.Filter {
+	if len(data) == 0 {
+		return domain.Filter{}
+	}
+	var stored storedFilter
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		log.Printf("Skip invalid filter '%s' of chat %v: %v", data, chatID, err)
+		return domain.Filter{}
+	}
+	return stored.toDomain(chatID)
+}
+
+// querySubscriptions returns subscriptions selected by query.
+// Query must return chat_id, filter, language_code and postcode ordered by chat_id and position of postcode
+func (s *sqlStorage) querySubscriptions(ctx context.Context, query string, args ...interface{}) ([]domain.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subs := []domain.Subscription{}
+	for rows.Next() {
+		var chatID domain.ChatID
+		var filter, languageCode string
+		var postcode sql.NullString
+		if err := rows.Scan(&chatID, &filter, &languageCode, &postcode); err != nil {
+			return nil, fmt.Errorf("failed to read subscription: %w", err)
+		}
+		if len(subs) == 0 || subs[len(subs)-1].ChatID != chatID {
+			subs = append(subs, domain.Subscription{
+				ChatID:       chatID,
+				Postcodes:    []domain.Postcode{},
+				Filter:       unmarshalFilter(chatID, filter),
+				LanguageCode: languageCode,
+			})
+		}
+		if !postcode.Valid {
+			continue
+		}
+		if canonical, ok := canonicalPostcode(chatID, postcode.String); ok {
+			subs[len(subs)-1].AddPostcode(canonical)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+func (s *sqlStorage) GetSubscriptions(ctx context.Context) ([]domain.Subscription, error) {
+	return s.querySubscriptions(ctx, `SELECT s.chat_id, s.filter, s.language_code, p.postcode FROM subscriptions s
+		LEFT JOIN subscription_postcodes p ON p.chat_id = s.chat_id
+		ORDER BY s.chat_id, p.position`)
+}
+
+func (s *sqlStorage) GetSubscriptionByID(ctx context.Context, chatID domain.ChatID) (domain.Subscription, error) {
+	subs, err := s.querySubscriptions(ctx, `SELECT s.chat_id, s.filter, s.language_code, p.postcode FROM subscriptions s
+		LEFT JOIN subscription_postcodes p ON p.chat_id = s.chat_id
+		WHERE s.chat_id = $1
+		ORDER BY s.chat_id, p.position`, int64(chatID))
+	if err != nil {
+		return domain.Subscription{}, fmt.Errorf("failed to get subscription by %v: %w", chatID, err)
+	}
+	if len(subs) == 0 {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return subs[0], nil
+}
+
+// MarkNotified stores the time when the chat was notified last time
+func (s *sqlStorage) MarkNotified(ctx context.Context, chatID domain.ChatID, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE subscriptions SET last_notified_at = $1 WHERE chat_id = $2`, at.UTC(), int64(chatID))
+	if err != nil {
+		return fmt.Errorf("failed to mark chat %v as notified: %w", chatID, err)
+	}
+	return nil
+}
+
+// GetSubscriptionHistory returns subscription events of the chat
+func (s *sqlStorage) GetSubscriptionHistory(ctx context.Context, chatID domain.ChatID) ([]SubscriptionEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT chat_id, postcode, action, created_at FROM subscription_history
+		WHERE chat_id = $1 ORDER BY created_at`, int64(chatID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription history: %w", err)
+	}
+	defer rows.Close()
+
+	events := []SubscriptionEvent{}
+	for rows.Next() {
+		var e SubscriptionEvent
+		if err := rows.Scan(&e.ChatID, &e.Postcode, &e.Action, &e.At); err != nil {
+			return nil, fmt.Errorf("failed to read subscription event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate subscription history: %w", err)
+	}
+	return events, nil
+}
+
+// GetLastNotified returns the time when the chat was notified last time
+func (s *sqlStorage) GetLastNotified(ctx context.Context, chatID domain.ChatID) (time.Time, error) {
+	var at sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT last_notified_at FROM subscriptions WHERE chat_id = $1`, int64(chatID)).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last notification of %v: %w", chatID, err)
+	}
+	return at.Time, nil
+}
+
+// Close closes the database, it waits for queries in progress
+func (s *sqlStorage) Close(ctx context.Context) error {
+	return closeWithContext(ctx, s.db.Close)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLStorage_AddGetRemove(t *testing.T) {
+	ctx := context.Background()
+	db := OpenTestSQLite(t)
+	s, err := NewSQLStorage(ctx, db)
+	assert.NoError(t, err)
+
+	// Act
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AA"}}))
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AB"}}))
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AC"}}))
+
+	sub, err := s.GetSubscriptionByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AC"}}, sub)
+
+	subs, err := s.GetSubscriptions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Subscription{
+		{ChatID: 1, Postcodes: []domain.Postcode{"1234AB"}},
+		{ChatID: 2, Postcodes: []domain.Postcode{"1234AC"}},
+	}, subs)
+
+	assert.NoError(t, s.RemoveSubscription(ctx, domain.Subscription{ChatID: 2}))
+	assert.NoError(t, s.RemoveSubscription(ctx, domain.Subscription{ChatID: 2}))
+
+	_, err = s.GetSubscriptionByID(ctx, 2)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSQLStorage_History(t *testing.T) {
+	ctx := context.Background()
+	db := OpenTestSQLite(t)
+	s, err := NewSQLStorage(ctx, db)
+	assert.NoError(t, err)
+	now := time.Date(2020, 5, 18, 10, 0, 0, 0, time.UTC)
+	s.(*sqlStorage).now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	// Act
+	assert.NoError(t, s.AddSubscription(ctx, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}}))
+	assert.NoError(t, s.(NotificationRecorder).MarkNotified(ctx, 1, time.Date(2020, 5, 18, 12, 0, 0, 0, time.UTC)))
+	lastNotified, err := s.(NotificationRecorder).GetLastNotified(ctx, 1)
+	assert.NoError(t, s.RemoveSubscription(ctx, domain.Subscription{ChatID: 1}))
+	events, historyErr := s.(HistoryStorer).GetSubscriptionHistory(ctx, 1)
+
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2020, 5, 18, 12, 0, 0, 0, time.UTC).Equal(lastNotified))
+	assert.NoError(t, historyErr)
+	assert.Len(t, events, 2)
+	assert.Equal(t, ActionSubscribed, events[0].Action)
+	assert.Equal(t, domain.Postcode("1234AA"), events[0].Postcode)
+	assert.True(t, time.Date(2020, 5, 18, 10, 1, 0, 0, time.UTC).Equal(events[0].At))
+	assert.Equal(t, ActionUnsubscribed, events[1].Action)
+}
+
+func TestSQLStorage_MigrateTwice(t *testing.T) {
+	ctx := context.Background()
+	db := OpenTestSQLite(t)
+	_, err := NewSQLStorage(ctx, db)
+	assert.NoError(t, err)
+
+	// Act
+	_, err = NewSQLStorage(ctx, db)
+
+	assert.NoError(t, err)
+	var version int
+	assert.NoError(t, db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, len(sqlMigrations), version)
+}
+
+func TestSQLStorage_MigrateSinglePostcode(t *testing.T) {
+	ctx := context.Background()
+	db := OpenTestSQLite(t)
+	migrations := sqlMigrations
+	sqlMigrations = migrations[:1]
+	_, err := NewSQLStorage(ctx, db)
+	sqlMigrations = migrations
+	assert.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO subscriptions (chat_id, postcode, subscribed_at, updated_at)
+		VALUES (1, '1234AA', $1, $1)`, time.Now())
+	assert.NoError(t, err)
+
+	// Act
+	s, err := NewSQLStorage(ctx, db)
+
+	assert.NoError(t, err)
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}}, sub)
+}
@@ -0,0 +1,242 @@
+// Package storagetest implements conformance tests for storage.DataStorer implementations
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/storage"
+)
+
+// NewStorer creates an empty storage for a single test.
+// Use t.Cleanup to release resources of the storage
+type NewStorer func(t *testing.T) storage.DataStorer
+
+// TestDataStorer runs the conformance suite against storages created by newStorer
+func TestDataStorer(t *testing.T, newStorer NewStorer) {
+	t.Run("AddSubscriptionUpserts", func(t *testing.T) {
+		testAddSubscriptionUpserts(t, newStorer(t))
+	})
+	t.Run("RemoveSubscriptionIsIdempotent", func(t *testing.T) {
+		testRemoveSubscriptionIsIdempotent(t, newStorer(t))
+	})
+	t.Run("GetSubscriptionByIDUnknownChat", func(t *testing.T) {
+		testGetSubscriptionByIDUnknownChat(t, newStorer(t))
+	})
+	t.Run("GetSubscriptionsConcurrentWrites", func(t *testing.T) {
+		testGetSubscriptionsConcurrentWrites(t, newStorer(t))
+	})
+	t.Run("FilterIsStored", func(t *testing.T) {
+		testFilterIsStored(t, newStorer(t))
+	})
+	t.Run("LanguageIsStored", func(t *testing.T) {
+		testLanguageIsStored(t, newStorer(t))
+	})
+	t.Run("Close", func(t *testing.T) {
+		testClose(t, newStorer(t))
+	})
+}
+
+func testClose(t *testing.T, s storage.DataStorer) {
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func testLanguageIsStored(t *testing.T, s storage.DataStorer) {
+	ctx := context.Background()
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}, LanguageCode: "nl-NL"})
+	mustAdd(t, s, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}})
+
+	// Act
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+
+	if err != nil {
+		t.Fatalf("GetSubscriptionByID: %v", err)
+	}
+	if sub.LanguageCode != "nl-NL" {
+		t.Errorf("GetSubscriptionByID returned language '%s', expected nl-NL", sub.LanguageCode)
+	}
+	subs := mustGetAll(t, s)
+	if len(subs) != 2 || subs[0].LanguageCode != "nl-NL" || subs[1].LanguageCode != "" {
+		t.Errorf("GetSubscriptions returned %+v, expected languages nl-NL and empty", subs)
+	}
+}
+
+func testFilterIsStored(t *testing.T, s storage.DataStorer) {
+	ctx := context.Background()
+	filter := domain.Filter{
+		Weekdays: []time.Weekday{time.Monday, time.Friday},
+		Windows:  []domain.TimeWindow{{Start: 18 * time.Hour, End: 24 * time.Hour}},
+		MinLead:  2 * time.Hour,
+		MaxLead:  72 * time.Hour,
+		MaxPrice: 5.95,
+	}
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}, Filter: filter})
+	mustAdd(t, s, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}})
+
+	// Act
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+
+	if err != nil {
+		t.Fatalf("GetSubscriptionByID: %v", err)
+	}
+	if !reflect.DeepEqual(sub.Filter, filter) {
+		t.Errorf("GetSubscriptionByID returned filter %+v, expected %+v", sub.Filter, filter)
+	}
+	subs := mustGetAll(t, s)
+	if len(subs) != 2 || !reflect.DeepEqual(subs[0].Filter, filter) || !subs[1].Filter.IsEmpty() {
+		t.Errorf("GetSubscriptions returned filters %+v, expected %+v and no filters", subs, filter)
+	}
+
+	// Act
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+
+	sub, err = s.GetSubscriptionByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetSubscriptionByID: %v", err)
+	}
+	if !sub.Filter.IsEmpty() {
+		t.Errorf("GetSubscriptionByID returned filter %+v after it was cleared", sub.Filter)
+	}
+}
+
+func testAddSubscriptionUpserts(t *testing.T, s storage.DataStorer) {
+	ctx := context.Background()
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	mustAdd(t, s, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}})
+
+	// Act
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AC", "1234AA", "1234AD"}})
+
+	sub, err := s.GetSubscriptionByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetSubscriptionByID: %v", err)
+	}
+	expected := []domain.Postcode{"1234AC", "1234AA", "1234AD"}
+	if !reflect.DeepEqual(sub.Postcodes, expected) {
+		t.Errorf("GetSubscriptionByID returned postcodes %v, expected %v", sub.Postcodes, expected)
+	}
+
+	subs := mustGetAll(t, s)
+	if len(subs) != 2 {
+		t.Errorf("GetSubscriptions returned %d subscriptions, expected 2: %+v", len(subs), subs)
+	}
+}
+
+func testRemoveSubscriptionIsIdempotent(t *testing.T, s storage.DataStorer) {
+	ctx := context.Background()
+	mustAdd(t, s, domain.Subscription{ChatID: 1, Postcodes: []domain.Postcode{"1234AA"}})
+	mustAdd(t, s, domain.Subscription{ChatID: 2, Postcodes: []domain.Postcode{"1234AB"}})
+
+	// Act
+	for i := 0; i < 2; i++ {
+		if err := s.RemoveSubscription(ctx, domain.Subscription{ChatID: 1}); err != nil {
+			t.Fatalf("RemoveSubscription #%d: %v", i+1, err)
+		}
+	}
+	if err := s.RemoveSubscription(ctx, domain.Subscription{ChatID: 3}); err != nil {
+		t.Fatalf("RemoveSubscription of unknown chat: %v", err)
+	}
+
+	if _, err := s.GetSubscriptionByID(ctx, 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetSubscriptionByID of removed chat returned %v, expected ErrNotFound", err)
+	}
+	subs := mustGetAll(t, s)
+	if len(subs) != 1 || subs[0].ChatID != 2 {
+		t.Errorf("GetSubscriptions returned %+v, expected only chat 2", subs)
+	}
+}
+
+func testGetSubscriptionByIDUnknownChat(t *testing.T, s storage.DataStorer) {
+	// Act
+	sub, err := s.GetSubscriptionByID(context.Background(), 42)
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetSubscriptionByID returned %v, expected ErrNotFound", err)
+	}
+	if sub.ChatID != 0 {
+		t.Errorf("GetSubscriptionByID returned %+v for unknown chat", sub)
+	}
+	if subs := mustGetAll(t, s); len(subs) != 0 {
+		t.Errorf("GetSubscriptions of empty storage returned %+v", subs)
+	}
+}
+
+func testGetSubscriptionsConcurrentWrites(t *testing.T, s storage.DataStorer) {
+	const writers = 10
+	const chatsPerWriter = 5
+	ctx := context.Background()
+
+	// Act
+	errs := make(chan error, writers*chatsPerWriter*2)
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < chatsPerWriter; i++ {
+				chatID := domain.ChatID(w*chatsPerWriter + i + 1)
+				if err := s.AddSubscription(ctx, domain.Subscription{ChatID: chatID, Postcodes: []domain.Postcode{"1234AA"}}); err != nil {
+					errs <- fmt.Errorf("AddSubscription %v: %w", chatID, err)
+				}
+				subs, err := s.GetSubscriptions(ctx)
+				if err != nil {
+					errs <- fmt.Errorf("GetSubscriptions: %w", err)
+					continue
+				}
+				if err := checkUnique(subs); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	subs := mustGetAll(t, s)
+	if len(subs) != writers*chatsPerWriter {
+		t.Errorf("GetSubscriptions returned %d subscriptions, expected %d", len(subs), writers*chatsPerWriter)
+	}
+	if err := checkUnique(subs); err != nil {
+		t.Error(err)
+	}
+}
+
+func checkUnique(subs []domain.Subscription) error {
+	seen := map[domain.ChatID]bool{}
+	for _, sub := range subs {
+		if seen[sub.ChatID] {
+			return fmt.Errorf("GetSubscriptions returned chat %v twice", sub.ChatID)
+		}
+		seen[sub.ChatID] = true
+	}
+	return nil
+}
+
+func mustAdd(t *testing.T, s storage.DataStorer, sub domain.Subscription) {
+	t.Helper()
+	if err := s.AddSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("AddSubscription %+v: %v", sub, err)
+	}
+}
+
+func mustGetAll(t *testing.T, s storage.DataStorer) []domain.Subscription {
+	t.Helper()
+	subs, err := s.GetSubscriptions(context.Background())
+	if err != nil {
+		t.Fatalf("GetSubscriptions: %v", err)
+	}
+	return subs
+}
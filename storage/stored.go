@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"log"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// storedSubscription is a stored representation of subscription.
+// Postcode is set in subscriptions which were stored before multiple postcodes were supported
+type storedSubscription struct {
+	ChatID    domain.ChatID
+	Postcodes []string
+	Postcode  string        `json:",omitempty" firestore:",omitempty"`
+	Filter    *storedFilter `json:",omitempty" firestore:",omitempty"`
+	// LanguageCode is empty in subscriptions which were stored before languages were kept
+	LanguageCode string `json:",omitempty" firestore:",omitempty"`
+}
+
+// storedFilter is a stored representation of filter. Time windows are stored like 18:00-22:00
+type storedFilter struct {
+	Weekdays []int         `json:",omitempty" firestore:",omitempty"`
+	Windows  []string      `json:",omitempty" firestore:",omitempty"`
+	MinLead  time.Duration `json:",omitempty" firestore:",omitempty"`
+	MaxLead  time.Duration `json:",omitempty" firestore:",omitempty"`
+	MaxPrice float64       `json:",omitempty" firestore:",omitempty"`
+}
+
+// newStoredFilter returns nil for empty filter, so subscriptions without filters are stored as before
+func newStoredFilter(filter domain.Filter) *storedFilter {
+	if filter.IsEmpty() {
+		return nil
+	}
+	f := storedFilter{
+		MinLead:  filter.MinLead,
+		MaxLead:  filter.MaxLead,
+		MaxPrice: filter.MaxPrice,
+	}
+	for _, day := range filter.Weekdays {
+		f.Weekdays = append(f.Weekdays, int(day))
+	}
+	for _, w := range filter.Windows {
+		f.Windows = append(f.Windows, w.String())
+	}
+	return &f
+}
+
+// toDomain returns filter of chat. Invalid time windows are skipped
+func (f *storedFilter) toDomain(chatID domain.ChatID) domain.Filter {
+	if f == nil {
+		return domain.Filter{}
+	}
+	filter := domain.Filter{
+		MinLead:  f.MinLead,
+		MaxLead:  f.MaxLead,
+		MaxPrice: f.MaxPrice,
+	}
+	for _, day := range f.Weekdays {
+		filter.Weekdays = append(filter.Weekdays, time.Weekday(day))
+	}
+	for _, stored := range f.Windows {
+		w, err := domain.ParseTimeWindow(stored)
+		if err != nil {
+			log.Printf("Skip invalid time window '%s' of chat %v: %v", stored, chatID, err)
+			continue
+		}
+		filter.Windows = append(filter.Windows, w)
+	}
+	return filter
+}
+
+func newStoredSubscription(sub domain.Subscription) storedSubscription {
+	s := storedSubscription{
+		ChatID:       sub.ChatID,
+		Postcodes:    []string{},
+		Filter:       newStoredFilter(sub.Filter),
+		LanguageCode: sub.LanguageCode,
+	}
+	for _, postcode := range sub.Postcodes {
+		s.Postcodes = append(s.Postcodes, postcode.String())
+	}
+	return s
+}
+
+func (s storedSubscription) toDomain() domain.Subscription {
+	sub := domain.Subscription{
+		ChatID:       s.ChatID,
+		Postcodes:    []domain.Postcode{},
+		Filter:       s.Filter.toDomain(s.ChatID),
+		LanguageCode: s.LanguageCode,
+	}
+	postcodes := s.Postcodes
+	if len(s.Postcode) > 0 {
+		postcodes = append([]string{s.Postcode}, postcodes...)
+	}
+	for _, stored := range postcodes {
+		if postcode, ok := canonicalPostcode(s.ChatID, stored); ok {
+			sub.AddPostcode(postcode)
+		}
+	}
+	return sub
+}
+
+// canonicalPostcode returns stored postcode in canonical form. Postcodes stored before validation
+// was introduced can be not canonical or invalid, invalid ones are skipped
+func canonicalPostcode(chatID domain.ChatID, stored string) (domain.Postcode, bool) {
+	postcode, err := domain.ParsePostcode(stored)
+	if err != nil {
+		log.Printf("Skip invalid postcode '%s' of chat %v: %v", stored, chatID, err)
+		return "", false
+	}
+	return postcode, true
+}
+
+// copySubscription returns subscription which doesn't share memory with sub
+func copySubscription(sub domain.Subscription) domain.Subscription {
+	if sub.Postcodes != nil {
+		sub.Postcodes = append([]domain.Postcode{}, sub.Postcodes...)
+	}
+	if sub.Filter.Weekdays != nil {
+		sub.Filter.Weekdays = append([]time.Weekday{}, sub.Filter.Weekdays...)
+	}
+	if sub.Filter.Windows != nil {
+		sub.Filter.Windows = append([]domain.TimeWindow{}, sub.Filter.Windows...)
+	}
+	return sub
+}
@@ -0,0 +1,28 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// BotCommand is a command shown by telegram clients in autocomplete
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// SetCommands calls setMyCommands, the library has no wrapper for it
+func (a *tlgMessenger) SetCommands(commands []BotCommand, languageCode string) error {
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("commands", string(data))
+	if len(languageCode) > 0 {
+		params.Set("language_code", languageCode)
+	}
+
+	_, err = a.botAPI.MakeRequest("setMyCommands", params)
+	return err
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baor/ah-helper-bot/domain"
+	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Kinds of send errors. Use errors.Is to check the kind of error returned by Messenger.Send
+var (
+	// ErrChatUnavailable means that messages can't be delivered to the chat anymore,
+	// e.g. the user has blocked the bot or the chat doesn't exist
+	ErrChatUnavailable = errors.New("chat is unavailable")
+	// ErrTooManyRequests means that telegram limits messages of the bot
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrSendFailed is any other failure of sending
+	ErrSendFailed = errors.New("failed to send message")
+)
+
+// chatUnavailableDescriptions are parts of error descriptions of telegram for unavailable chats.
+// The library doesn't expose error codes, so errors are classified by description
+var chatUnavailableDescriptions = []string{
+	"bot was blocked by the user",
+	"chat not found",
+	"user is deactivated",
+	"bot was kicked",
+	"bot is not a member",
+	"have no rights to send a message",
+}
+
+// SendError describes failed sending of message
+type SendError struct {
+	// Kind is one of ErrChatUnavailable, ErrTooManyRequests or ErrSendFailed
+	Kind   error
+	ChatID domain.ChatID
+	// RetryAfter is a delay requested by telegram for ErrTooManyRequests
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%v to chat %s: %v", e.Kind, e.ChatID, e.Err)
+}
+
+// Is reports whether target is the kind of error
+func (e *SendError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Unwrap returns the underlying error
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError converts error of telegram API to SendError
+func classifyError(chatID domain.ChatID, err error) *SendError {
+	sendErr := &SendError{Kind: ErrSendFailed, ChatID: chatID, Err: err}
+
+	var apiErr tlg.Error
+	if !errors.As(err, &apiErr) {
+		return sendErr
+	}
+	if apiErr.RetryAfter > 0 || strings.HasPrefix(apiErr.Message, "Too Many Requests") {
+		sendErr.Kind = ErrTooManyRequests
+		sendErr.RetryAfter = time.Duration(apiErr.RetryAfter) * time.Second
+		return sendErr
+	}
+	description := strings.ToLower(apiErr.Message)
+	for _, unavailable := range chatUnavailableDescriptions {
+		if strings.Contains(description, unavailable) {
+			sendErr.Kind = ErrChatUnavailable
+			return sendErr
+		}
+	}
+	return sendErr
+}
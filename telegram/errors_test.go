@@ -0,0 +1,40 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		kind       error
+		retryAfter time.Duration
+	}{
+		{"blocked", tlg.Error{Message: "Forbidden: bot was blocked by the user"}, ErrChatUnavailable, 0},
+		{"chat not found", tlg.Error{Message: "Bad Request: chat not found"}, ErrChatUnavailable, 0},
+		{"deactivated", tlg.Error{Message: "Forbidden: user is deactivated"}, ErrChatUnavailable, 0},
+		{"kicked", tlg.Error{Message: "Forbidden: bot was kicked from the group chat"}, ErrChatUnavailable, 0},
+		{"too many requests", tlg.Error{
+			Message:            "Too Many Requests: retry after 5",
+			ResponseParameters: tlg.ResponseParameters{RetryAfter: 5},
+		}, ErrTooManyRequests, 5 * time.Second},
+		{"bad request", tlg.Error{Message: "Bad Request: can't parse entities"}, ErrSendFailed, 0},
+		{"network", errors.New("connection reset by peer"), ErrSendFailed, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			err := classifyError(1, tc.err)
+
+			assert.True(t, errors.Is(err, tc.kind), "unexpected error %v", err)
+			assert.Equal(t, tc.retryAfter, err.RetryAfter)
+			assert.Equal(t, tc.err, errors.Unwrap(err))
+		})
+	}
+}
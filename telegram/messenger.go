@@ -1,90 +1,189 @@
 package telegram
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
 	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+// MaxMessageLength is the longest text of message in UTF-16 code units accepted by telegram
+const MaxMessageLength = 4096
+
 // Messenger is an inteface which describes basic messenger functionality
 type Messenger interface {
-	Send(m domain.Message)
+	// Send delivers message to the chat. Errors are *SendError
+	Send(m domain.Message) error
+	// UserName of the bot account
+	UserName() string
+	// SetCommands publishes commands for autocomplete of users with languageCode.
+	// Empty languageCode sets the default commands
+	SetCommands(commands []BotCommand, languageCode string) error
+	// Run receives updates until ctx is done. The update in process is finished before Run returns
+	Run(ctx context.Context)
 }
 
-// MessageProcessor is a function to process updates in telegram chat
-type MessageProcessor func(msg domain.Message)
+// ContextSender is implemented by messengers whose sending stops waiting when ctx is done
+type ContextSender interface {
+	// SendContext delivers message to the chat like Send, waiting before retries ends when ctx is done
+	SendContext(ctx context.Context, m domain.Message) error
+}
 
 // tlgMessenger is an adapter for telegram bot functionality
 type tlgMessenger struct {
-	botAPI           *tlg.BotAPI
-	updatesCh        tlg.UpdatesChannel
-	messageProcessor MessageProcessor
+	botAPI *tlg.BotAPI
+	bus    *events.Bus
+	// polling messenger receives updates by getUpdates, otherwise updates are pushed to webhook
+	polling bool
+	// sendAttempts limits sending of message limited by telegram
+	sendAttempts int
+	// maxRetryAfter is the longest delay before the next attempt. Longer delays requested by telegram fail sending
+	maxRetryAfter time.Duration
+	// after waits before the next attempt, time.After by default
+	after func(time.Duration) <-chan time.Time
+	// secretToken is expected in X-Telegram-Bot-Api-Secret-Token header of webhook requests
+	secretToken string
+	// parseMode renders formatted messages
+	parseMode ParseMode
 }
 
-// NewMessenger is a constructor
-func NewMessenger(token string, messageProcessor MessageProcessor, delay time.Duration) Messenger {
-	var err error
+// newTlgMessenger authorizes the bot
+func newTlgMessenger(token string, bus *events.Bus, parseMode ParseMode) *tlgMessenger {
+	botAPI, err := tlg.NewBotAPI(token)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	botAPI.Debug = false
+	log.Printf("Telegram bot authorized on account %s", botAPI.Self.UserName)
 
+	return &tlgMessenger{
+		botAPI:        botAPI,
+		bus:           bus,
+		parseMode:     parseMode,
+		sendAttempts:  3,
+		maxRetryAfter: 30 * time.Second,
+		after:         time.After,
+	}
+}
+
+// NewMessenger is a constructor of messenger which receives updates by long polling in Run.
+// Received messages are published on bus as CommandReceived events, formatted messages are sent in parseMode
+func NewMessenger(token string, bus *events.Bus, parseMode ParseMode) Messenger {
 	if token == "" {
 		log.Println("Warning! Token is empty! Return nil adapter")
 		return nil
 	}
 
-	a := tlgMessenger{}
-	a.botAPI, err = tlg.NewBotAPI(token)
-	if err != nil {
+	a := newTlgMessenger(token, bus, parseMode)
+	a.polling = true
+
+	// updates are not delivered by getUpdates while a webhook is set
+	if _, err := a.botAPI.RemoveWebhook(); err != nil {
 		log.Panic(err)
 	}
 
-	a.botAPI.Debug = false
-	log.Printf("Telegram bot authorized on account %s", a.botAPI.Self.UserName)
+	return a
+}
 
-	u := tlg.NewUpdate(0)
-	u.Timeout = 60
+// Send message to telegram. Formatted message is rendered in parse mode of messenger,
+// if telegram rejects its formatting, the message is sent again as plain text
+func (a *tlgMessenger) Send(m domain.Message) error {
+	return a.SendContext(context.Background(), m)
+}
 
-	a.updatesCh, err = a.botAPI.GetUpdatesChan(u)
-	if err != nil {
-		log.Panic(err)
+// SendContext sends message like Send. Sending limited by telegram is not retried after ctx is done
+func (a *tlgMessenger) SendContext(ctx context.Context, m domain.Message) error {
+	if len(m.Rich) == 0 {
+		return a.send(ctx, tlg.NewMessage(int64(m.ChatID), m.Text))
 	}
 
-	a.messageProcessor = messageProcessor
+	botMsg := tlg.NewMessage(int64(m.ChatID), render(m.Rich, a.parseMode))
+	botMsg.ParseMode = string(a.parseMode)
+	err := a.send(ctx, botMsg)
+	if !isEntitiesError(err) {
+		return err
+	}
+	log.Printf("Formatting of message to chat %s is rejected, send plain text: %v", m.ChatID, err)
+	return a.send(ctx, tlg.NewMessage(int64(m.ChatID), m.Rich.String()))
+}
+
+// send message. Sending limited by telegram is retried after the requested delay unless ctx is done
+func (a *tlgMessenger) send(ctx context.Context, botMsg tlg.MessageConfig) error {
+	log.Printf("Send message: %+v", botMsg)
+	chatID := domain.ChatID(botMsg.ChatID)
 
-	go a.updatesListener(delay)
+	for attempt := 1; ; attempt++ {
+		_, err := a.botAPI.Send(botMsg)
+		if err == nil {
+			return nil
+		}
+		sendErr := classifyError(chatID, err)
+		if sendErr.Kind != ErrTooManyRequests || attempt >= a.sendAttempts || sendErr.RetryAfter > a.maxRetryAfter {
+			return sendErr
+		}
+		log.Printf("Sending to chat %s is limited, retry after %s", chatID, sendErr.RetryAfter)
+		select {
+		case <-ctx.Done():
+			return sendErr
+		case <-a.after(sendErr.RetryAfter):
+		}
+	}
+}
 
-	return &a
+// UserName returns username of the bot account, e.g. AHHelperBot
+func (a *tlgMessenger) UserName() string {
+	return a.botAPI.Self.UserName
 }
 
-// Send will send a Chattable item to Telegram.
-//
-// It requires the Chattable to send.
-func (a *tlgMessenger) Send(m domain.Message) {
-	botMsg := tlg.NewMessage(int64(m.ChatID), m.Text)
-	botMsg.ParseMode = "Markdown"
-	log.Printf("Send message: %+v", botMsg)
-	_, err := a.botAPI.Send(botMsg)
+// Run polls updates until ctx is done. Webhook messenger only waits for ctx,
+// its updates are processed by the HTTP server
+func (a *tlgMessenger) Run(ctx context.Context) {
+	if !a.polling {
+		<-ctx.Done()
+		return
+	}
+
+	u := tlg.NewUpdate(0)
+	u.Timeout = 60
+
+	updatesCh, err := a.botAPI.GetUpdatesChan(u)
 	if err != nil {
-		log.Panic(err)
+		log.Printf("Messenger failed to receive updates: %v", err)
+		return
 	}
-}
+	defer a.botAPI.StopReceivingUpdates()
 
-func (a *tlgMessenger) updatesListener(delay time.Duration) {
 	for {
 		select {
-		case u := <-a.updatesCh:
-			if u.Message == nil || u.Message.Chat == nil || len(u.Message.Text) == 0 {
-				continue
-			}
-
-			message := domain.Message{
-				ChatID: domain.ChatID(u.Message.Chat.ID),
-				Text:   u.Message.Text,
-			}
-			log.Printf("Messenger received message: %+v", message)
-			a.messageProcessor(message)
-		default:
-			time.Sleep(delay)
+		case <-ctx.Done():
+			log.Printf("Messenger stops receiving updates")
+			return
+		case u := <-updatesCh:
+			// the update is finished even if ctx is done meanwhile
+			a.processUpdate(context.Background(), u)
 		}
 	}
 }
+
+// processUpdate publishes text message of update on bus. Other updates are ignored
+func (a *tlgMessenger) processUpdate(ctx context.Context, u tlg.Update) {
+	if u.Message == nil || u.Message.Chat == nil || len(u.Message.Text) == 0 {
+		return
+	}
+
+	message := domain.Message{
+		ChatID: domain.ChatID(u.Message.Chat.ID),
+		Text:   u.Message.Text,
+	}
+	if u.Message.From != nil {
+		message.LanguageCode = u.Message.From.LanguageCode
+	}
+	log.Printf("Messenger received message: %+v", message)
+	if err := a.bus.Publish(ctx, events.CommandReceived{Message: message}); err != nil {
+		log.Printf("Messenger failed to process message %+v: %v", message, err)
+	}
+}
@@ -0,0 +1,227 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// newTestMessenger returns messenger which sends requests of telegram API to handler
+func newTestMessenger(t *testing.T, handler http.HandlerFunc) *tlgMessenger {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	client := &http.Client{Transport: rewriteTransport{target: serverURL}}
+	return &tlgMessenger{
+		botAPI:        &tlg.BotAPI{Token: "token", Client: client},
+		parseMode:     ParseModeMarkdownV2,
+		sendAttempts:  3,
+		maxRetryAfter: 30 * time.Second,
+		after:         afterNow,
+	}
+}
+
+// afterNow is a wait before retry which is over immediately
+func afterNow(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+// rewriteTransport sends all requests to target
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMessenger_SetCommands(t *testing.T) {
+	var form url.Values
+	var path string
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"ok": true, "result": true}`))
+	})
+
+	// Act
+	err := m.SetCommands([]BotCommand{{Command: "list", Description: "toon je postcodes"}}, "nl")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/bottoken/setMyCommands", path)
+	assert.JSONEq(t, `[{"command": "list", "description": "toon je postcodes"}]`, form.Get("commands"))
+	assert.Equal(t, "nl", form.Get("language_code"))
+}
+
+func TestMessenger_SetCommandsDefaultLanguage(t *testing.T) {
+	var form url.Values
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"ok": true, "result": true}`))
+	})
+
+	// Act
+	err := m.SetCommands([]BotCommand{{Command: "list", Description: "show your postcodes"}}, "")
+
+	assert.NoError(t, err)
+	_, ok := form["language_code"]
+	assert.False(t, ok)
+}
+
+func TestMessenger_SetCommandsRejected(t *testing.T) {
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "description": "Bad Request: command description is empty"}`))
+	})
+
+	// Act
+	err := m.SetCommands([]BotCommand{{Command: "list"}}, "")
+
+	assert.EqualError(t, err, "Bad Request: command description is empty")
+}
+
+const sentMessage = `{"ok": true, "result": {"message_id": 1, "date": 1589796000, "chat": {"id": 1, "type": "private"}}}`
+
+func TestMessenger_Send(t *testing.T) {
+	var form url.Values
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(sentMessage))
+	})
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1", form.Get("chat_id"))
+	assert.Equal(t, "test", form.Get("text"))
+}
+
+func TestMessenger_SendRich(t *testing.T) {
+	var form url.Values
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(sentMessage))
+	})
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Rich: domain.RichText{domain.Bold("1234AB"), domain.Plain(" 08:00-10:00")}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "*1234AB* 08:00\\-10:00", form.Get("text"))
+	assert.Equal(t, "MarkdownV2", form.Get("parse_mode"))
+}
+
+func TestMessenger_SendRichFallbackToPlainText(t *testing.T) {
+	forms := []url.Values{}
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		if len(forms) == 1 {
+			w.Write([]byte(`{"ok": false, "error_code": 400,
+				"description": "Bad Request: can't parse entities: Character '-' is reserved and must be escaped"}`))
+			return
+		}
+		w.Write([]byte(sentMessage))
+	})
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Rich: domain.RichText{domain.Bold("1234AB"), domain.Plain(" 08:00-10:00")}})
+
+	assert.NoError(t, err)
+	assert.Len(t, forms, 2)
+	assert.Equal(t, "1234AB 08:00-10:00", forms[1].Get("text"))
+	assert.Empty(t, forms[1].Get("parse_mode"))
+}
+
+func TestMessenger_SendRetriesTooManyRequests(t *testing.T) {
+	calls := 0
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Write([]byte(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 2",
+				"parameters": {"retry_after": 2}}`))
+			return
+		}
+		w.Write([]byte(sentMessage))
+	})
+	slept := []time.Duration{}
+	m.after = func(d time.Duration) <-chan time.Time {
+		slept = append(slept, d)
+		return afterNow(d)
+	}
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []time.Duration{2 * time.Second}, slept)
+}
+
+func TestMessenger_SendContextDoneStopsRetrying(t *testing.T) {
+	calls := 0
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 2",
+			"parameters": {"retry_after": 2}}`))
+	})
+	m.after = time.After
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+
+	// Act
+	err := m.SendContext(ctx, domain.Message{ChatID: 1, Text: "test"})
+
+	assert.True(t, errors.Is(err, ErrTooManyRequests), "unexpected error %v", err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, time.Since(start) < time.Second, "retry waits after ctx is done")
+}
+
+func TestMessenger_SendRetryAfterTooLong(t *testing.T) {
+	calls := 0
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 60",
+			"parameters": {"retry_after": 60}}`))
+	})
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.True(t, errors.Is(err, ErrTooManyRequests), "unexpected error %v", err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMessenger_SendBlocked(t *testing.T) {
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error_code": 403, "description": "Forbidden: bot was blocked by the user"}`))
+	})
+
+	// Act
+	err := m.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.True(t, errors.Is(err, ErrChatUnavailable), "unexpected error %v", err)
+	var sendErr *SendError
+	assert.True(t, errors.As(err, &sendErr))
+	assert.Equal(t, domain.ChatID(1), sendErr.ChatID)
+}
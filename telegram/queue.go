@@ -0,0 +1,256 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// ErrQueueClosed is returned for messages which are not sent before the queue is closed
+var ErrQueueClosed = errors.New("outgoing queue is closed")
+
+// Queue is an outgoing queue of messages which keeps telegram limits: messages per second overall
+// and an interval between messages to the same chat. Replies go before broadcast messages,
+// messages to the same chat are sent in order. Queue implements Messenger on top of another messenger
+type Queue struct {
+	messenger Messenger
+	limiter   *rate.Limiter
+	// perChat is a minimum interval between messages to the same chat, a bucket of one token per chat
+	perChat time.Duration
+	now     func() time.Time
+
+	mu         sync.Mutex
+	replies    []*queuedMessage
+	broadcasts []*queuedMessage
+	inFlight   map[domain.ChatID]bool
+	nextByChat map[domain.ChatID]time.Time
+	closed     bool
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopped is closed when dispatching is stopped, done when messages in flight are finished too
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+type queuedMessage struct {
+	msg    domain.Message
+	result chan error
+}
+
+// NewQueue starts queue which sends at most perSecond messages by messenger and at most
+// one message per perChat interval to every chat. Close stops the queue
+func NewQueue(messenger Messenger, perSecond float64, perChat time.Duration) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		messenger:  messenger,
+		limiter:    rate.NewLimiter(rate.Limit(perSecond), 1),
+		perChat:    perChat,
+		now:        time.Now,
+		inFlight:   map[domain.ChatID]bool{},
+		nextByChat: map[domain.ChatID]time.Time{},
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go q.dispatch()
+	return q
+}
+
+// Send queues message and waits until it is sent. Broadcast messages wait for replies
+func (q *Queue) Send(m domain.Message) error {
+	item := &queuedMessage{msg: m, result: make(chan error, 1)}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	if m.Broadcast {
+		q.broadcasts = append(q.broadcasts, item)
+	} else {
+		q.replies = append(q.replies, item)
+	}
+	q.mu.Unlock()
+	q.notify()
+
+	return <-item.result
+}
+
+// Depth returns numbers of queued replies and broadcast messages
+func (q *Queue) Depth() (replies int, broadcasts int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.replies), len(q.broadcasts)
+}
+
+// UserName of the bot account
+func (q *Queue) UserName() string {
+	return q.messenger.UserName()
+}
+
+// SetCommands publishes commands by the underlying messenger
+func (q *Queue) SetCommands(commands []BotCommand, languageCode string) error {
+	return q.messenger.SetCommands(commands, languageCode)
+}
+
+// Run receives updates by the underlying messenger
+func (q *Queue) Run(ctx context.Context) {
+	q.messenger.Run(ctx)
+}
+
+// Close stops taking new messages and sends queued ones until ctx is done. Then messages which are
+// still queued fail with ErrQueueClosed and Close returns without waiting for messages in flight,
+// their retries are cancelled
+func (q *Queue) Close(ctx context.Context) {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+	}
+	q.cancel()
+	<-q.stopped
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch sends queued messages until the queue is closed and drained or cancelled
+func (q *Queue) dispatch() {
+	defer close(q.done)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	defer close(q.stopped)
+
+	for {
+		item, wait := q.next()
+		if item == nil {
+			if q.drained() {
+				return
+			}
+			if !q.wait(wait) {
+				q.failQueued()
+				return
+			}
+			continue
+		}
+
+		if err := q.limiter.Wait(q.ctx); err != nil {
+			item.result <- ErrQueueClosed
+			q.failQueued()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.send(item)
+		}()
+	}
+}
+
+// wait blocks until a message is queued or sent, the timeout is over or the queue is closed.
+// Zero timeout is infinite. It returns false if the queue is closed
+func (q *Queue) wait(timeout time.Duration) bool {
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case <-q.wake:
+	case <-timeoutCh:
+	case <-q.ctx.Done():
+		return false
+	}
+	return true
+}
+
+// next takes the first message in priority order whose chat is ready. Otherwise it returns
+// the time until a queued message gets ready, or zero if it is unknown
+func (q *Queue) next() (*queuedMessage, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for chatID, next := range q.nextByChat {
+		if !q.inFlight[chatID] && !now.Before(next) {
+			delete(q.nextByChat, chatID)
+		}
+	}
+
+	var wait time.Duration
+	for _, queue := range []*[]*queuedMessage{&q.replies, &q.broadcasts} {
+		for i, item := range *queue {
+			chatID := item.msg.ChatID
+			if q.inFlight[chatID] {
+				continue
+			}
+			if next, ok := q.nextByChat[chatID]; ok && now.Before(next) {
+				if d := next.Sub(now); wait == 0 || d < wait {
+					wait = d
+				}
+				continue
+			}
+
+			*queue = append((*queue)[:i:i], (*queue)[i+1:]...)
+			q.inFlight[chatID] = true
+			q.nextByChat[chatID] = now.Add(q.perChat)
+			return item, 0
+		}
+	}
+	return nil, wait
+}
+
+// send message by the underlying messenger. Messenger implementing ContextSender stops retrying
+// when the queue is cancelled
+func (q *Queue) send(item *queuedMessage) {
+	var err error
+	if sender, ok := q.messenger.(ContextSender); ok {
+		err = sender.SendContext(q.ctx, item.msg)
+	} else {
+		err = q.messenger.Send(item.msg)
+	}
+
+	q.mu.Lock()
+	delete(q.inFlight, item.msg.ChatID)
+	q.mu.Unlock()
+	q.notify()
+
+	item.result <- err
+}
+
+// drained reports whether the queue is closed and all messages are taken for sending
+func (q *Queue) drained() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed && len(q.replies) == 0 && len(q.broadcasts) == 0
+}
+
+// failQueued fails messages which are not sent yet
+func (q *Queue) failQueued() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range append(q.replies, q.broadcasts...) {
+		item.result <- ErrQueueClosed
+	}
+	q.replies = nil
+	q.broadcasts = nil
+}
@@ -0,0 +1,244 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// recordingMessenger records sent messages. Sending to blocked chat waits until it is released
+type recordingMessenger struct {
+	mu      sync.Mutex
+	sent    []domain.Message
+	sentAt  []time.Time
+	blocked map[domain.ChatID]chan struct{}
+	err     error
+}
+
+func (m *recordingMessenger) Send(msg domain.Message) error {
+	m.mu.Lock()
+	gate := m.blocked[msg.ChatID]
+	m.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	m.sentAt = append(m.sentAt, time.Now())
+	return m.err
+}
+
+func (m *recordingMessenger) texts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	texts := []string{}
+	for _, msg := range m.sent {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func (m *recordingMessenger) UserName() string { return "AHHelperBot" }
+
+func (m *recordingMessenger) SetCommands(commands []BotCommand, languageCode string) error {
+	return nil
+}
+
+func (m *recordingMessenger) Run(ctx context.Context) { <-ctx.Done() }
+
+func sendAsync(q *Queue, msg domain.Message, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.Send(msg)
+	}()
+}
+
+func waitForDepth(t *testing.T, q *Queue, replies int, broadcasts int) {
+	assert.Eventually(t, func() bool {
+		r, b := q.Depth()
+		return r == replies && b == broadcasts
+	}, time.Second, time.Millisecond)
+}
+
+func TestQueue_Send(t *testing.T) {
+	m := &recordingMessenger{}
+	q := NewQueue(m, 1000, time.Millisecond)
+	defer q.Close(context.Background())
+
+	// Act
+	err := q.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test"}, m.texts())
+}
+
+func TestQueue_SendReturnsError(t *testing.T) {
+	m := &recordingMessenger{err: &SendError{Kind: ErrChatUnavailable, ChatID: 1}}
+	q := NewQueue(m, 1000, time.Millisecond)
+	defer q.Close(context.Background())
+
+	// Act
+	err := q.Send(domain.Message{ChatID: 1, Text: "test"})
+
+	assert.True(t, errors.Is(err, ErrChatUnavailable))
+}
+
+func TestQueue_RepliesBeforeBroadcasts(t *testing.T) {
+	gate := make(chan struct{})
+	m := &recordingMessenger{blocked: map[domain.ChatID]chan struct{}{1: gate}}
+	q := NewQueue(m, 1000, time.Millisecond)
+	defer q.Close(context.Background())
+	wg := sync.WaitGroup{}
+
+	// the queue is busy with the chat 1, messages to the chat 1 wait
+	sendAsync(q, domain.Message{ChatID: 1, Text: "first"}, &wg)
+	waitForDepth(t, q, 0, 0)
+	sendAsync(q, domain.Message{ChatID: 1, Text: "broadcast", Broadcast: true}, &wg)
+	waitForDepth(t, q, 0, 1)
+	sendAsync(q, domain.Message{ChatID: 1, Text: "reply"}, &wg)
+	waitForDepth(t, q, 1, 1)
+
+	// Act
+	close(gate)
+	wg.Wait()
+
+	assert.Equal(t, []string{"first", "reply", "broadcast"}, m.texts())
+}
+
+func TestQueue_PerChatInterval(t *testing.T) {
+	m := &recordingMessenger{}
+	q := NewQueue(m, 1000, 50*time.Millisecond)
+	defer q.Close(context.Background())
+	wg := sync.WaitGroup{}
+
+	// Act
+	for i := 0; i < 3; i++ {
+		sendAsync(q, domain.Message{ChatID: 1, Text: "chat 1", Broadcast: true}, &wg)
+	}
+	waitForDepth(t, q, 0, 2)
+	sendAsync(q, domain.Message{ChatID: 2, Text: "chat 2", Broadcast: true}, &wg)
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var previous time.Time
+	for i, msg := range m.sent {
+		if msg.ChatID != 1 {
+			continue
+		}
+		if !previous.IsZero() {
+			assert.True(t, m.sentAt[i].Sub(previous) >= 45*time.Millisecond, "messages to chat 1 are sent too often")
+		}
+		previous = m.sentAt[i]
+	}
+	assert.NotEqual(t, domain.ChatID(2), m.sent[3].ChatID, "chat 2 should not wait for chat 1")
+}
+
+func TestQueue_GlobalRate(t *testing.T) {
+	m := &recordingMessenger{}
+	q := NewQueue(m, 20, time.Millisecond)
+	defer q.Close(context.Background())
+	wg := sync.WaitGroup{}
+	started := time.Now()
+
+	// Act
+	for i := 1; i <= 5; i++ {
+		sendAsync(q, domain.Message{ChatID: domain.ChatID(i), Text: "test"}, &wg)
+	}
+	wg.Wait()
+
+	assert.True(t, time.Since(started) >= 180*time.Millisecond, "5 messages are sent faster than 20 per second")
+}
+
+func TestQueue_CloseDrainsQueued(t *testing.T) {
+	gate := make(chan struct{})
+	m := &recordingMessenger{blocked: map[domain.ChatID]chan struct{}{1: gate}}
+	q := NewQueue(m, 1000, time.Millisecond)
+	wg := sync.WaitGroup{}
+	sendAsync(q, domain.Message{ChatID: 1, Text: "in flight"}, &wg)
+	waitForDepth(t, q, 0, 0)
+	var queuedErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		queuedErr = q.Send(domain.Message{ChatID: 1, Text: "queued", Broadcast: true})
+	}()
+	waitForDepth(t, q, 0, 1)
+
+	// Act
+	go close(gate)
+	q.Close(context.Background())
+	wg.Wait()
+
+	assert.NoError(t, queuedErr)
+	assert.Equal(t, []string{"in flight", "queued"}, m.texts())
+	assert.Equal(t, ErrQueueClosed, q.Send(domain.Message{ChatID: 2, Text: "late"}))
+}
+
+func TestQueue_CloseDeadline(t *testing.T) {
+	gate := make(chan struct{})
+	m := &recordingMessenger{blocked: map[domain.ChatID]chan struct{}{1: gate}}
+	q := NewQueue(m, 1000, time.Millisecond)
+	inFlight := sync.WaitGroup{}
+	sendAsync(q, domain.Message{ChatID: 1, Text: "in flight"}, &inFlight)
+	waitForDepth(t, q, 0, 0)
+	queued := make(chan error, 1)
+	go func() {
+		queued <- q.Send(domain.Message{ChatID: 1, Text: "queued"})
+	}()
+	waitForDepth(t, q, 1, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	closed := make(chan struct{})
+
+	// Act
+	go func() {
+		q.Close(ctx)
+		close(closed)
+	}()
+
+	assert.Equal(t, ErrQueueClosed, <-queued)
+	// Close does not wait for the message in flight
+	<-closed
+	close(gate)
+	inFlight.Wait()
+	assert.Equal(t, []string{"in flight"}, m.texts())
+}
+
+// contextMessenger sends until ctx of sending is done
+type contextMessenger struct {
+	recordingMessenger
+	started chan struct{}
+}
+
+func (m *contextMessenger) SendContext(ctx context.Context, msg domain.Message) error {
+	close(m.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestQueue_CloseCancelsSendContext(t *testing.T) {
+	m := &contextMessenger{started: make(chan struct{})}
+	q := NewQueue(m, 1000, time.Millisecond)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- q.Send(domain.Message{ChatID: 1, Text: "in flight"})
+	}()
+	<-m.started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	q.Close(ctx)
+
+	assert.Equal(t, context.Canceled, <-sent)
+}
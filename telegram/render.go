@@ -0,0 +1,94 @@
+package telegram
+
+import (
+	"html"
+	"strings"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+// ParseMode of telegram for formatted messages
+type ParseMode string
+
+// Supported parse modes
+const (
+	ParseModeMarkdownV2 ParseMode = "MarkdownV2"
+	ParseModeHTML       ParseMode = "HTML"
+)
+
+var (
+	// markdownV2Escaper escapes characters which are reserved everywhere in MarkdownV2
+	markdownV2Escaper = newBackslashEscaper("_*[]()~`>#+-=|{}.!\\")
+	// markdownV2CodeEscaper escapes characters which are reserved inside code
+	markdownV2CodeEscaper = newBackslashEscaper("`\\")
+	// markdownV2URLEscaper escapes characters which are reserved inside URL of link
+	markdownV2URLEscaper = newBackslashEscaper(")\\")
+)
+
+// newBackslashEscaper returns replacer which prepends every of chars with backslash
+func newBackslashEscaper(chars string) *strings.Replacer {
+	pairs := []string{}
+	for _, c := range chars {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// render returns text formatted for mode with all reserved characters escaped
+func render(t domain.RichText, mode ParseMode) string {
+	var text strings.Builder
+	for _, part := range t {
+		if mode == ParseModeHTML {
+			text.WriteString(renderHTML(part))
+		} else {
+			text.WriteString(renderMarkdownV2(part))
+		}
+	}
+	return text.String()
+}
+
+func renderMarkdownV2(part domain.Part) string {
+	switch part.Style {
+	case domain.StyleBold:
+		return "*" + markdownV2Escaper.Replace(part.Text) + "*"
+	case domain.StyleCode:
+		return "`" + markdownV2CodeEscaper.Replace(part.Text) + "`"
+	case domain.StyleLink:
+		return "[" + markdownV2Escaper.Replace(part.Text) + "](" + markdownV2URLEscaper.Replace(part.URL) + ")"
+	case domain.StyleList:
+		return renderList(part, ParseModeMarkdownV2)
+	}
+	return markdownV2Escaper.Replace(part.Text)
+}
+
+func renderHTML(part domain.Part) string {
+	switch part.Style {
+	case domain.StyleBold:
+		return "<b>" + html.EscapeString(part.Text) + "</b>"
+	case domain.StyleCode:
+		return "<code>" + html.EscapeString(part.Text) + "</code>"
+	case domain.StyleLink:
+		return `<a href="` + html.EscapeString(part.URL) + `">` + html.EscapeString(part.Text) + "</a>"
+	case domain.StyleList:
+		return renderList(part, ParseModeHTML)
+	}
+	return html.EscapeString(part.Text)
+}
+
+// renderList renders every item on its own line. Telegram has no list entities, so items start with a bullet
+func renderList(part domain.Part, mode ParseMode) string {
+	var text strings.Builder
+	for _, item := range part.Items {
+		text.WriteString(domain.ListBullet + render(item, mode) + "\n")
+	}
+	return text.String()
+}
+
+// isEntitiesError reports whether telegram has rejected formatting of message
+func isEntitiesError(err error) bool {
+	if err == nil {
+		return false
+	}
+	description := strings.ToLower(err.Error())
+	return strings.Contains(description, "can't parse entities") || strings.Contains(description, "can't find end of")
+}
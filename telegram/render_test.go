@@ -0,0 +1,48 @@
+package telegram
+
+import (
+	"testing"
+
+	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+)
+
+var testRichText = domain.RichText{
+	domain.Bold("ma 18-05"),
+	domain.Plain(": 08:00-10:00 (€ 1.50) <new>!\n"),
+	domain.Code("a`b\\c_d"),
+	domain.Plain("\n"),
+	domain.Link("AH [bezorgen]", "https://www.ah.nl/kies-moment?a=(1)&b=2"),
+	domain.Plain("\n"),
+	domain.List(domain.RichText{domain.Plain("1234AB")}, domain.RichText{domain.Bold("1234_AC")}),
+}
+
+func TestRender_MarkdownV2(t *testing.T) {
+	// Act
+	text := render(testRichText, ParseModeMarkdownV2)
+
+	assert.Equal(t, "*ma 18\\-05*: 08:00\\-10:00 \\(€ 1\\.50\\) <new\\>\\!\n"+
+		"`a\\`b\\\\c_d`\n"+
+		"[AH \\[bezorgen\\]](https://www.ah.nl/kies-moment?a=(1\\)&b=2)\n"+
+		"• 1234AB\n"+
+		"• *1234\\_AC*\n", text)
+}
+
+func TestRender_HTML(t *testing.T) {
+	// Act
+	text := render(testRichText, ParseModeHTML)
+
+	assert.Equal(t, "<b>ma 18-05</b>: 08:00-10:00 (€ 1.50) &lt;new&gt;!\n"+
+		"<code>a`b\\c_d</code>\n"+
+		`<a href="https://www.ah.nl/kies-moment?a=(1)&amp;b=2">AH [bezorgen]</a>`+"\n"+
+		"• 1234AB\n"+
+		"• <b>1234_AC</b>\n", text)
+}
+
+func TestIsEntitiesError(t *testing.T) {
+	assert.True(t, isEntitiesError(classifyError(1, tlg.Error{Message: "Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 3"})))
+	assert.False(t, isEntitiesError(classifyError(1, tlg.Error{Message: "Forbidden: bot was blocked by the user"})))
+	assert.False(t, isEntitiesError(nil))
+}
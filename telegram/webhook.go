@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/baor/ah-helper-bot/events"
+	tlg "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// secretTokenHeader is set by telegram in webhook requests to the secret_token of setWebhook
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// NewWebhookMessenger is a constructor of messenger which receives updates by webhook.
+// The webhook of bot is set to webhookURL, the returned handler must be served on it.
+// Requests without secretToken in X-Telegram-Bot-Api-Secret-Token header are rejected.
+// Formatted messages are sent in parseMode
+func NewWebhookMessenger(token string, bus *events.Bus, webhookURL string, secretToken string, parseMode ParseMode) (Messenger, http.Handler) {
+	if token == "" {
+		log.Println("Warning! Token is empty! Return nil adapter")
+		return nil, nil
+	}
+	if secretToken == "" {
+		log.Panic("Empty secret token of webhook")
+	}
+
+	a := newTlgMessenger(token, bus, parseMode)
+	a.secretToken = secretToken
+	if err := a.setWebhook(webhookURL); err != nil {
+		log.Panic(err)
+	}
+	log.Printf("Telegram webhook is set to %s", webhookURL)
+
+	return a, a
+}
+
+// setWebhook calls setWebhook with secret_token, the library has no support for it
+func (a *tlgMessenger) setWebhook(webhookURL string) error {
+	params := url.Values{}
+	params.Set("url", webhookURL)
+	params.Set("secret_token", a.secretToken)
+	params.Set("allowed_updates", `["message"]`)
+
+	_, err := a.botAPI.MakeRequest("setWebhook", params)
+	return err
+}
+
+// ServeHTTP processes an update pushed by telegram
+func (a *tlgMessenger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secretToken := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(secretToken), []byte(a.secretToken)) != 1 {
+		log.Printf("Webhook request with invalid secret token from %s", r.RemoteAddr)
+		http.Error(w, "invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	var u tlg.Update
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		log.Printf("Webhook request with invalid update: %v", err)
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	a.processUpdate(r.Context(), u)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baor/ah-helper-bot/domain"
+	"github.com/baor/ah-helper-bot/events"
+)
+
+const testUpdate = `{"update_id": 1, "message": {"message_id": 2, "date": 1589796000,
+	"from": {"id": 3, "first_name": "Anna", "language_code": "nl"},
+	"chat": {"id": 3, "type": "private"}, "text": "/check"}}`
+
+func newTestWebhook(received *[]domain.Message) *tlgMessenger {
+	bus := events.NewBus()
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		if e, ok := event.(events.CommandReceived); ok {
+			*received = append(*received, e.Message)
+		}
+		return nil
+	})
+	return &tlgMessenger{bus: bus, secretToken: "secret"}
+}
+
+func TestWebhook_ServeHTTP(t *testing.T) {
+	testCases := []struct {
+		name        string
+		method      string
+		secretToken string
+		body        string
+		status      int
+		received    []domain.Message
+	}{
+		{"update", http.MethodPost, "secret", testUpdate, http.StatusOK,
+			[]domain.Message{{ChatID: 3, Text: "/check", LanguageCode: "nl"}}},
+		{"not a message", http.MethodPost, "secret", `{"update_id": 1, "edited_message": {"message_id": 2}}`, http.StatusOK, nil},
+		{"missing secret token", http.MethodPost, "", testUpdate, http.StatusUnauthorized, nil},
+		{"invalid secret token", http.MethodPost, "secreT", testUpdate, http.StatusUnauthorized, nil},
+		{"invalid update", http.MethodPost, "secret", `{"update_id": "one"}`, http.StatusBadRequest, nil},
+		{"not post", http.MethodGet, "secret", "", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received []domain.Message
+			m := newTestWebhook(&received)
+			r := httptest.NewRequest(tc.method, "/telegram", strings.NewReader(tc.body))
+			if len(tc.secretToken) > 0 {
+				r.Header.Set(secretTokenHeader, tc.secretToken)
+			}
+			w := httptest.NewRecorder()
+
+			// Act
+			m.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, tc.received, received)
+		})
+	}
+}
+
+func TestWebhook_SetWebhook(t *testing.T) {
+	var form url.Values
+	var path string
+	m := newTestMessenger(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"ok": true, "result": true}`))
+	})
+	m.secretToken = "secret"
+
+	// Act
+	err := m.setWebhook("https://example.com/telegram")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/bottoken/setWebhook", path)
+	assert.Equal(t, "https://example.com/telegram", form.Get("url"))
+	assert.Equal(t, "secret", form.Get("secret_token"))
+	assert.Equal(t, `["message"]`, form.Get("allowed_updates"))
+}
+
+func TestWebhook_RunStopsOnContextDone(t *testing.T) {
+	var received []domain.Message
+	m := newTestWebhook(&received)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(stopped)
+	}()
+
+	// Act
+	cancel()
+
+	<-stopped
+}